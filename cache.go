@@ -0,0 +1,230 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// buildIDLayout is the format of the build IDs of images.linuxcontainers.org.
+const buildIDLayout = "20060102_15:04"
+
+// CachedImage is an image stored in the download template's cache.
+type CachedImage struct {
+	Distribution string
+	Release      string
+	Arch         string
+	Variant      string
+
+	// BuildID identifies the build of the image on the image server.
+	BuildID string
+
+	// Built is the parsed BuildID, or when the image was cached if the
+	// BuildID couldn't be parsed.
+	Built time.Time
+
+	// Size is the disk space used by the image.
+	Size ByteSize
+
+	// Path is the directory the image is cached in.
+	Path string
+}
+
+// DownloadCache is the image cache of the download template.
+//
+// The download template doesn't lock its cache, images shouldn't be removed
+// while containers are created from them.
+type DownloadCache struct {
+	path string
+}
+
+// ImageCache returns the image cache of the download template. The cache is
+// looked up where the template does by default, the base directory
+// (e.g. /var/cache/lxc) can be passed instead.
+func ImageCache(cachePath ...string) *DownloadCache {
+	base := ""
+	if len(cachePath) > 0 {
+		base = cachePath[0]
+	} else {
+		base = defaultCachePath()
+	}
+
+	return &DownloadCache{path: filepath.Join(base, "download")}
+}
+
+// defaultCachePath returns the cache base directory of the download
+// template, which is per user for unprivileged users.
+func defaultCachePath() string {
+	if path := os.Getenv("LXC_CACHE_PATH"); path != "" {
+		return path
+	}
+
+	if os.Geteuid() == 0 {
+		return "/var/cache/lxc"
+	}
+
+	if path := os.Getenv("XDG_CACHE_HOME"); path != "" {
+		return filepath.Join(path, "lxc")
+	}
+
+	return filepath.Join(os.Getenv("HOME"), ".cache", "lxc")
+}
+
+// Path returns the directory of the cache.
+func (d *DownloadCache) Path() string {
+	return d.path
+}
+
+// Images returns the cached images sorted by distribution, release,
+// architecture and variant. A missing cache has no images.
+func (d *DownloadCache) Images() ([]CachedImage, error) {
+	// Images are cached in <distribution>/<release>/<arch>/<variant>.
+	dirs, err := filepath.Glob(filepath.Join(d.path, "*", "*", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]CachedImage, 0, len(dirs))
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		image, err := d.image(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *image)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Path < images[j].Path
+	})
+
+	return images, nil
+}
+
+// image reads the cached image in dir, which doesn't exist if it has no
+// build ID.
+func (d *DownloadCache) image(dir string) (*CachedImage, error) {
+	rel, err := filepath.Rel(d.path, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Split(rel, string(filepath.Separator))
+	if len(fields) != 4 {
+		return nil, ErrImageNotCached
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "build_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "build_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	image := &CachedImage{
+		Distribution: fields[0],
+		Release:      fields[1],
+		Arch:         fields[2],
+		Variant:      fields[3],
+		BuildID:      strings.TrimSpace(string(content)),
+		Path:         dir,
+	}
+
+	image.Built, err = time.Parse(buildIDLayout, image.BuildID)
+	if err != nil {
+		image.Built = info.ModTime()
+	}
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			image.Size += ByteSize(info.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// Remove removes the image from the cache, it is downloaded again the next
+// time a container is created from it.
+func (d *DownloadCache) Remove(image CachedImage) error {
+	for _, field := range []string{image.Distribution, image.Release, image.Arch, image.Variant} {
+		if field == "" || field == "." || field == ".." || strings.ContainsRune(field, filepath.Separator) {
+			return ErrImageNotCached
+		}
+	}
+
+	// Don't trust image.Path, it may not be in the cache.
+	dir := filepath.Join(d.path, image.Distribution, image.Release, image.Arch, image.Variant)
+	if _, err := os.Stat(filepath.Join(dir, "build_id")); err != nil {
+		if os.IsNotExist(err) {
+			return ErrImageNotCached
+		}
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	// Remove the parent directories left empty, stopping at the first
+	// which isn't.
+	for i := 0; i < 3; i++ {
+		dir = filepath.Dir(dir)
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Prune removes the images built more than olderThan ago and returns them.
+func (d *DownloadCache) Prune(olderThan time.Duration) ([]CachedImage, error) {
+	images, err := d.Images()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+
+	var pruned []CachedImage
+	for _, image := range images {
+		if !image.Built.Before(cutoff) {
+			continue
+		}
+
+		if err := d.Remove(image); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, image)
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,412 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	cgroup2Once     sync.Once
+	cgroup2Detected bool
+)
+
+// cgroup2 returns true if the host only uses the unified (v2) cgroup hierarchy.
+func cgroup2() bool {
+	cgroup2Once.Do(func() {
+		var fs unix.Statfs_t
+		if err := unix.Statfs("/sys/fs/cgroup", &fs); err != nil {
+			return
+		}
+		cgroup2Detected = fs.Type == unix.CGROUP2_SUPER_MAGIC
+	})
+
+	return cgroup2Detected
+}
+
+// cgroupRoot is where the cgroup hierarchies are mounted on the host.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// Caller needs to hold the lock
+func (c *Container) cgroupPath(controller string) (string, error) {
+	pid := c.initPid()
+	if pid <= 0 {
+		return "", fmt.Errorf("%s: %q", ErrNotRunning, c.name())
+	}
+
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+
+	hierarchy, path, err := findCgroupPath(strings.Split(string(content), "\n"), controller, c.name())
+	if err != nil {
+		return "", err
+	}
+
+	// Hybrid hosts mount the unified hierarchy below the legacy ones.
+	if controller == "" && !cgroup2() {
+		hierarchy = "unified"
+	}
+
+	return filepath.Join(cgroupRoot, hierarchy, path), nil
+}
+
+// findCgroupPath returns the hierarchy and the path of the container's cgroup
+// for the given controller (empty for the unified hierarchy) using the content
+// of /proc/<pid>/cgroup of its init process. As init may have moved itself into
+// a child cgroup (e.g. systemd's init.scope), the path is trimmed to the cgroup
+// created by LXC for the container.
+func findCgroupPath(lines []string, controller string, name string) (string, string, error) {
+	for _, line := range lines {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		if controller == "" {
+			if fields[0] != "0" || fields[1] != "" {
+				continue
+			}
+		} else {
+			found := false
+			for _, v := range strings.Split(fields[1], ",") {
+				if v == controller {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+
+		parts := strings.Split(fields[2], "/")
+		for i, part := range parts {
+			// LXC >= 4.0 uses lxc.payload.<name>, older versions lxc/<name>.
+			if part == "lxc.payload."+name {
+				parts = parts[:i+1]
+				break
+			}
+			if part == "lxc" && i+1 < len(parts) && parts[i+1] == name {
+				parts = parts[:i+2]
+				break
+			}
+		}
+
+		return fields[1], strings.Join(parts, "/"), nil
+	}
+
+	return "", "", ErrCgroupNotFound
+}
+
+// Caller needs to hold the lock
+func (c *Container) cgroupValue(key string) string {
+	item := c.cgroupItem(key)
+	if len(item) == 0 {
+		return ""
+	}
+	return item[0]
+}
+
+// pollFd calls fn from a single goroutine every time fd is ready for the
+// given poll events, until fn returns false, the context is done or fd
+// reports an error, e.g. because its cgroup was removed. fd is then closed
+// and cleanup called.
+func pollFd(ctx context.Context, fd int, events int16, fn func() bool, cleanup func()) error {
+	// The pipe wakes up poll once the context is done.
+	var pipe [2]int
+	if err := unix.Pipe2(pipe[:], unix.O_CLOEXEC); err != nil {
+		return err
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		unix.Close(pipe[1])
+	}()
+
+	go func() {
+		defer cleanup()
+		defer unix.Close(fd)
+		defer unix.Close(pipe[0])
+		defer close(stopped)
+
+		fds := []unix.PollFd{
+			{Fd: int32(fd), Events: events},
+			{Fd: int32(pipe[0]), Events: unix.POLLIN},
+		}
+
+		for {
+			_, err := unix.Poll(fds, -1)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil || fds[1].Revents != 0 || fds[0].Revents&(unix.POLLERR|unix.POLLNVAL) != 0 {
+				return
+			}
+
+			if fds[0].Revents&events != 0 && !fn() {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// cgroupUnlimited is the value cgroup v1 reports for an unset limit (PAGE_COUNTER_MAX
+// rounded to the page size), anything above it is treated as unlimited as well.
+const cgroupUnlimited = 1 << 62
+
+// parseCgroupLimit parses a cgroup limit value. Both "max" and the cgroup v1
+// sentinel for no limit are returned as -1.
+func parseCgroupLimit(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "max" || value == "-1" {
+		return -1, nil
+	}
+
+	limit, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return -1, err
+	}
+
+	if limit >= cgroupUnlimited {
+		return -1, nil
+	}
+	return int64(limit), nil
+}
+
+// parseFlatKeyed parses the "key value" lines used by files like memory.stat,
+// cpu.stat or pids.events.
+func parseFlatKeyed(lines []string) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed cgroup line %q", line)
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[fields[0]] = value
+	}
+
+	return values, nil
+}
+
+// parseNestedKeyed parses the "key subkey=value ..." lines used by files like
+// io.stat or io.max. Values that are not numbers (e.g. "max") are skipped.
+func parseNestedKeyed(lines []string) (map[string]map[string]uint64, error) {
+	values := make(map[string]map[string]uint64)
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry := make(map[string]uint64)
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("malformed cgroup line %q", line)
+			}
+
+			value, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			entry[kv[0]] = value
+		}
+		values[fields[0]] = entry
+	}
+
+	return values, nil
+}
+
+// parseDeviceNumber parses a "major:minor" device number.
+func parseDeviceNumber(device string) (uint32, uint32, error) {
+	parts := strings.SplitN(device, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("malformed device number %q", device)
+	}
+
+	major, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	minor, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint32(major), uint32(minor), nil
+}
+
+// parseCPUList parses a cpuset list like "0-3,8,10-11" into the sorted list
+// of the CPUs (or memory nodes) it contains.
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return cpus, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, chunk := range strings.Split(list, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(chunk), "-", 2)
+
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("malformed cpuset list %q", list)
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("malformed cpuset list %q", list)
+			}
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			if !seen[cpu] {
+				seen[cpu] = true
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	sort.Ints(cpus)
+
+	return cpus, nil
+}
+
+// cgroupSetting is a cgroup file along with the value to write to it.
+type cgroupSetting struct {
+	key   string
+	value string
+}
+
+// cgroupConfigPrefix returns the prefix of the config keys holding the cgroup
+// settings applied on container start.
+func cgroupConfigPrefix() string {
+	if cgroup2() {
+		return "lxc.cgroup2"
+	}
+	return "lxc.cgroup"
+}
+
+// Caller needs to hold the lock
+func (c *Container) setCgroupSettings(settings []cgroupSetting) error {
+	for _, setting := range settings {
+		if err := c.setCgroupItem(setting.key, setting.value); err != nil {
+			return fmt.Errorf("%s: %s=%q", err, setting.key, setting.value)
+		}
+	}
+	return nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) persistCgroupSettings(settings []cgroupSetting) error {
+	prefix := cgroupConfigPrefix()
+
+	for _, setting := range settings {
+		key := fmt.Sprintf("%s.%s", prefix, setting.key)
+
+		// Drop any previous value so the config doesn't accumulate entries.
+		_ = c.clearConfigItem(key)
+
+		if err := c.setConfigItem(key, setting.value); err != nil {
+			return fmt.Errorf("%s: %s=%q", err, key, setting.value)
+		}
+	}
+
+	return c.saveConfigFile(c.configFileName())
+}
+
+// Caller needs to hold the lock
+func (c *Container) persistDeviceCgroupSettings(settings []cgroupSetting) error {
+	prefix := cgroupConfigPrefix()
+
+	// Files like io.max hold one line per device, so only the entries of the
+	// devices being changed are replaced.
+	var keys []string
+	values := make(map[string][]string)
+	for _, setting := range settings {
+		if _, ok := values[setting.key]; !ok {
+			keys = append(keys, setting.key)
+		}
+		values[setting.key] = append(values[setting.key], setting.value)
+	}
+
+	for _, key := range keys {
+		fullKey := fmt.Sprintf("%s.%s", prefix, key)
+
+		devices := make(map[string]bool)
+		var entries []string
+		for _, value := range values[key] {
+			fields := strings.Fields(value)
+			devices[fields[0]] = true
+
+			if !cgroupDeviceSettingUnset(fields[1:]) {
+				entries = append(entries, value)
+			}
+		}
+
+		var kept []string
+		for _, value := range c.configItem(fullKey) {
+			fields := strings.Fields(value)
+			if len(fields) > 0 && !devices[fields[0]] {
+				kept = append(kept, value)
+			}
+		}
+
+		_ = c.clearConfigItem(fullKey)
+
+		for _, value := range append(kept, entries...) {
+			if err := c.setConfigItem(fullKey, value); err != nil {
+				return fmt.Errorf("%s: %s=%q", err, fullKey, value)
+			}
+		}
+	}
+
+	return c.saveConfigFile(c.configFileName())
+}
+
+// cgroupDeviceSettingUnset returns true if the per device values remove all limits.
+func cgroupDeviceSettingUnset(values []string) bool {
+	for _, value := range values {
+		if value != "0" && !strings.HasSuffix(value, "=max") {
+			return false
+		}
+	}
+	return true
+}
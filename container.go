@@ -43,6 +43,9 @@ type Snapshot struct {
 	CommentPath string
 	Timestamp   string
 	Path        string
+
+	// Time is the parsed Timestamp, zero if it couldn't be parsed.
+	Time time.Time
 }
 
 const (
@@ -190,6 +193,11 @@ func (c *Container) Controllable() bool {
 
 // CreateSnapshot creates a new snapshot.
 func (c *Container) CreateSnapshot() (*Snapshot, error) {
+	return c.CreateSnapshotWithOptions(SnapshotOptions{})
+}
+
+// CreateSnapshotWithOptions creates a new snapshot using the given options.
+func (c *Container) CreateSnapshotWithOptions(options SnapshotOptions) (*Snapshot, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -201,11 +209,41 @@ func (c *Container) CreateSnapshot() (*Snapshot, error) {
 		return nil, err
 	}
 
-	ret := int(C.go_lxc_snapshot(c.container))
+	var ccommentfile *C.char
+	if options.Comment != "" {
+		// liblxc copies the comment file into the snapshot.
+		commentfile, err := ioutil.TempFile("", "go-lxc-snapshot-comment-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(commentfile.Name())
+
+		_, err = commentfile.WriteString(options.Comment)
+		if closeErr := commentfile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ccommentfile = C.CString(commentfile.Name())
+		defer C.free(unsafe.Pointer(ccommentfile))
+	}
+
+	ret := int(C.go_lxc_snapshot(c.container, ccommentfile))
 	if ret < 0 {
 		return nil, ErrCreateSnapshotFailed
 	}
-	return &Snapshot{Name: fmt.Sprintf("snap%d", ret)}, nil
+
+	name := fmt.Sprintf("snap%d", ret)
+	snapshots, _ := c.snapshots()
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return &snapshot, nil
+		}
+	}
+
+	return &Snapshot{Name: name}, nil
 }
 
 // RestoreSnapshot creates a new container based on a snapshot.
@@ -287,6 +325,11 @@ func (c *Container) Snapshots() ([]Snapshot, error) {
 		return nil, err
 	}
 
+	return c.snapshots()
+}
+
+// Caller needs to hold the lock
+func (c *Container) snapshots() ([]Snapshot, error) {
 	var csnapshots *C.struct_lxc_snapshot
 
 	size := int(C.go_lxc_snapshot_list(c.container, &csnapshots))
@@ -305,6 +348,7 @@ func (c *Container) Snapshots() ([]Snapshot, error) {
 			CommentPath: C.GoString(gosnapshots[i].comment_pathname),
 			Path:        C.GoString(gosnapshots[i].lxcpath),
 		}
+		snapshots[i].Time, _ = parseSnapshotTimestamp(snapshots[i].Timestamp)
 	}
 
 	return snapshots, nil
@@ -327,17 +371,22 @@ func (c *Container) State() State {
 	return c.state()
 }
 
+// Caller needs to hold the lock
+func (c *Container) initPid() int {
+	if c.container == nil {
+		return -1
+	}
+
+	return int(C.go_lxc_init_pid(c.container))
+}
+
 // InitPid returns the process ID of the container's init process
 // seen from outside the container.
 func (c *Container) InitPid() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	if c.container == nil {
-		return -1
-	}
-
-	return int(C.go_lxc_init_pid(c.container))
+	return c.initPid()
 }
 
 // InitPidFd returns the pidfd of the container's init process.
@@ -802,22 +851,22 @@ func (c *Container) DestroyWithAllSnapshots() error {
 
 // Clone clones the container using given arguments with specified backend.
 func (c *Container) Clone(name string, options CloneOptions) error {
+	clone, err := c.CloneContainer(name, options)
+	if err != nil {
+		return err
+	}
+
+	return clone.Release()
+}
+
+// CloneContainer clones the container using given arguments with specified
+// backend and returns the new container.
+func (c *Container) CloneContainer(name string, options CloneOptions) (*Container, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// FIXME: bdevdata, newsize and hookargs
-	//
-	// bdevdata:
-	// zfs requires zfsroot
-	// lvm requires lvname/vgname/thinpool as well as fstype and fssize
-	// btrfs requires nothing
-	// dir requires nothing
-	//
-	// newsize: for blockdev-backed backingstores
-	//
-	// hookargs: additional arguments to pass to the clone hook script
 	if err := c.makeSure(isDefined | isNotRunning); err != nil {
-		return err
+		return nil, err
 	}
 
 	// use Directory backend if not set
@@ -835,6 +884,32 @@ func (c *Container) Clone(name string, options CloneOptions) error {
 	if options.Snapshot {
 		flags |= C.LXC_CLONE_SNAPSHOT
 	}
+	if options.MaybeSnapshot {
+		flags |= C.LXC_CLONE_MAYBE_SNAPSHOT
+	}
+	if options.KeepBdevType {
+		flags |= C.LXC_CLONE_KEEPBDEVTYPE
+	}
+
+	newsize := uint64(options.NewSize)
+
+	// bdevdata holds the filesystem type of the new storage.
+	var cbdevdata *C.char
+	if specs := options.BackendSpecs; specs != nil {
+		if specs.Dir != nil || specs.ZFS.Root != "" || specs.LVM.VG != "" || specs.LVM.LV != "" ||
+			specs.LVM.Thinpool != "" || specs.RBD.Name != "" || specs.RBD.Pool != "" {
+			return nil, fmt.Errorf("%s: only FSType and FSSize can be set when cloning", ErrCloneFailed)
+		}
+
+		if specs.FSType != "" {
+			cbdevdata = C.CString(specs.FSType)
+			defer C.free(unsafe.Pointer(cbdevdata))
+		}
+
+		if newsize == 0 {
+			newsize = specs.FSSize
+		}
+	}
 
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
@@ -842,19 +917,27 @@ func (c *Container) Clone(name string, options CloneOptions) error {
 	cbackend := C.CString(options.Backend.String())
 	defer C.free(unsafe.Pointer(cbackend))
 
+	var clxcpath *C.char
 	if options.ConfigPath != "" {
-		clxcpath := C.CString(options.ConfigPath)
+		clxcpath = C.CString(options.ConfigPath)
 		defer C.free(unsafe.Pointer(clxcpath))
+	}
 
-		if !bool(C.go_lxc_clone(c.container, cname, clxcpath, C.int(flags), cbackend)) {
-			return ErrCloneFailed
-		}
-	} else {
-		if !bool(C.go_lxc_clone(c.container, cname, nil, C.int(flags), cbackend)) {
-			return ErrCloneFailed
+	var chookargs **C.char
+	if len(options.HookArgs) > 0 {
+		chookargs = makeNullTerminatedArgs(options.HookArgs)
+		if chookargs == nil {
+			return nil, ErrAllocationFailed
 		}
+		defer freeNullTerminatedArgs(chookargs, len(options.HookArgs))
 	}
-	return nil
+
+	container := C.go_lxc_clone(c.container, cname, clxcpath, C.int(flags), cbackend, cbdevdata, C.uint64_t(newsize), chookargs)
+	if container == nil {
+		return nil, ErrCloneFailed
+	}
+
+	return &Container{container: container, verbosity: Quiet}, nil
 }
 
 // Rename renames the container.
@@ -894,11 +977,7 @@ func (c *Container) Wait(state State, timeout time.Duration) bool {
 	return bool(C.go_lxc_wait(c.container, cstate, C.int(timeout.Seconds())))
 }
 
-// ConfigFileName returns the container's configuration file's name.
-func (c *Container) ConfigFileName() string {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
+func (c *Container) configFileName() string {
 	if c.container == nil {
 		return ""
 	}
@@ -910,6 +989,14 @@ func (c *Container) ConfigFileName() string {
 	return C.GoString(configFileName)
 }
 
+// ConfigFileName returns the container's configuration file's name.
+func (c *Container) ConfigFileName() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.configFileName()
+}
+
 func (c *Container) configItem(key string) []string {
 	if c.container == nil {
 		return nil
@@ -1044,11 +1131,7 @@ func (c *Container) ClearConfig() {
 	C.go_lxc_clear_config(c.container)
 }
 
-// ClearConfigItem clears the value of given config item.
-func (c *Container) ClearConfigItem(key string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
+func (c *Container) clearConfigItem(key string) error {
 	if c.container == nil {
 		return ErrNotDefined
 	}
@@ -1062,6 +1145,14 @@ func (c *Container) ClearConfigItem(key string) error {
 	return nil
 }
 
+// ClearConfigItem clears the value of given config item.
+func (c *Container) ClearConfigItem(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.clearConfigItem(key)
+}
+
 // ConfigKeys returns the names of the config items.
 func (c *Container) ConfigKeys(key ...string) []string {
 	c.mu.RLock()
@@ -1298,6 +1389,80 @@ func (c *Container) SetMemorySwapLimit(limit ByteSize) error {
 	return c.setCgroupItemWithByteSize("memory.memsw.limit_in_bytes", limit, ErrSettingMemorySwapLimitFailed)
 }
 
+// PidsCurrent returns the number of tasks in the container.
+func (c *Container) PidsCurrent() (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return -1, err
+	}
+
+	current, err := strconv.ParseInt(c.cgroupValue("pids.current"), 10, 64)
+	if err != nil {
+		return -1, ErrPidsLimit
+	}
+	return current, nil
+}
+
+// PidsLimit returns the maximum number of tasks in the container, -1 if unlimited.
+func (c *Container) PidsLimit() (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return -1, err
+	}
+
+	limit, err := parseCgroupLimit(c.cgroupValue("pids.max"))
+	if err != nil {
+		return -1, ErrPidsLimit
+	}
+	return limit, nil
+}
+
+// SetPidsLimit sets the maximum number of tasks in the container, a negative limit removes it.
+func (c *Container) SetPidsLimit(limit int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return err
+	}
+
+	value := "max"
+	if limit >= 0 {
+		value = strconv.FormatInt(limit, 10)
+	}
+
+	if err := c.setCgroupItem("pids.max", value); err != nil {
+		return ErrSettingPidsLimitFailed
+	}
+	return nil
+}
+
+// PidsLimitHits returns how many times forking in the container failed because
+// the pids limit was reached.
+func (c *Container) PidsLimitHits() (uint64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return 0, err
+	}
+
+	events := c.cgroupItem("pids.events")
+	if events[0] == "" {
+		return 0, ErrPidsLimit
+	}
+
+	values, err := parseFlatKeyed(events)
+	if err != nil {
+		return 0, err
+	}
+	return values["max"], nil
+}
+
 // BlkioUsage returns number of bytes transferred to/from the disk by the container.
 func (c *Container) BlkioUsage() (ByteSize, error) {
 	c.mu.RLock()
@@ -0,0 +1,300 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DeviceType type specifies the kind of device a DeviceRule applies to.
+type DeviceType int
+
+const (
+	// AllDevices matches both character and block devices
+	AllDevices DeviceType = iota + 1
+	// CharDevice matches character devices
+	CharDevice
+	// BlockDevice matches block devices
+	BlockDevice
+)
+
+// DeviceType as string
+func (t DeviceType) String() string {
+	switch t {
+	case AllDevices:
+		return "a"
+	case CharDevice:
+		return "c"
+	case BlockDevice:
+		return "b"
+	}
+	return ""
+}
+
+// AnyDevice matches any major or minor number in a DeviceRule.
+const AnyDevice = -1
+
+// DeviceRule represents an entry of the devices cgroup controller.
+type DeviceRule struct {
+	Type DeviceType
+
+	// Major and Minor are the device numbers, AnyDevice to match all of them.
+	Major int64
+	Minor int64
+
+	// Access is any combination of "r" (read), "w" (write) and "m" (mknod).
+	Access string
+
+	// Allow is false for rules denying access.
+	Allow bool
+}
+
+// String returns the rule in the devices.allow and devices.deny format, e.g. "c 10:229 rwm".
+func (r DeviceRule) String() string {
+	if r.Type == AllDevices {
+		return "a"
+	}
+
+	number := func(n int64) string {
+		if n == AnyDevice {
+			return "*"
+		}
+		return strconv.FormatInt(n, 10)
+	}
+
+	return fmt.Sprintf("%s %s:%s %s", r.Type, number(r.Major), number(r.Minor), r.Access)
+}
+
+func (r DeviceRule) validate() error {
+	if r.Type.String() == "" {
+		return fmt.Errorf("%s: invalid device type %d", ErrInvalidDeviceRule, r.Type)
+	}
+
+	if r.Type == AllDevices {
+		return nil
+	}
+
+	if r.Major < AnyDevice || r.Minor < AnyDevice {
+		return fmt.Errorf("%s: invalid device number %d:%d", ErrInvalidDeviceRule, r.Major, r.Minor)
+	}
+
+	if r.Access == "" || strings.Trim(r.Access, "rwm") != "" {
+		return fmt.Errorf("%s: invalid access %q", ErrInvalidDeviceRule, r.Access)
+	}
+
+	return nil
+}
+
+// parseDeviceRule parses an entry of devices.list or of the devices.allow and
+// devices.deny config keys.
+func parseDeviceRule(rule string, allow bool) (DeviceRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 {
+		return DeviceRule{}, fmt.Errorf("%s: %q", ErrInvalidDeviceRule, rule)
+	}
+
+	result := DeviceRule{Major: AnyDevice, Minor: AnyDevice, Access: "rwm", Allow: allow}
+
+	switch fields[0] {
+	case "a":
+		// devices.list reports "a *:* rwm" when everything is allowed.
+		result.Type = AllDevices
+		return result, nil
+	case "c":
+		result.Type = CharDevice
+	case "b":
+		result.Type = BlockDevice
+	default:
+		return DeviceRule{}, fmt.Errorf("%s: %q", ErrInvalidDeviceRule, rule)
+	}
+
+	if len(fields) != 3 {
+		return DeviceRule{}, fmt.Errorf("%s: %q", ErrInvalidDeviceRule, rule)
+	}
+
+	numbers := strings.SplitN(fields[1], ":", 2)
+	if len(numbers) != 2 {
+		return DeviceRule{}, fmt.Errorf("%s: %q", ErrInvalidDeviceRule, rule)
+	}
+
+	for i, number := range []*int64{&result.Major, &result.Minor} {
+		if numbers[i] == "*" {
+			continue
+		}
+
+		value, err := strconv.ParseInt(numbers[i], 10, 64)
+		if err != nil || value < 0 {
+			return DeviceRule{}, fmt.Errorf("%s: %q", ErrInvalidDeviceRule, rule)
+		}
+		*number = value
+	}
+	result.Access = fields[2]
+
+	return result, result.validate()
+}
+
+// DeviceRules returns the device access rules of the container. For running
+// containers on cgroup v1 these are the allowed devices reported by the kernel,
+// otherwise the rules from the container's configuration, in order.
+func (c *Container) DeviceRules() ([]DeviceRule, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.container == nil {
+		return nil, ErrNotDefined
+	}
+
+	var rules []DeviceRule
+
+	if c.running() && !cgroup2() {
+		for _, line := range c.cgroupItem("devices.list") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			rule, err := parseDeviceRule(line, true)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+
+		return rules, nil
+	}
+
+	for _, entry := range c.deviceEntries() {
+		rule, err := parseDeviceRule(entry[1], entry[0] == "allow")
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// AllowDevice grants the container access to the devices matching the rule.
+// The Allow field of the rule is ignored.
+func (c *Container) AllowDevice(rule DeviceRule, options LimitOptions) error {
+	rule.Allow = true
+	return c.addDeviceRule(rule, options)
+}
+
+// DenyDevice revokes the container's access to the devices matching the rule.
+// The Allow field of the rule is ignored.
+func (c *Container) DenyDevice(rule DeviceRule, options LimitOptions) error {
+	rule.Allow = false
+	return c.addDeviceRule(rule, options)
+}
+
+func deviceRuleAction(allow bool) string {
+	if allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+func (c *Container) addDeviceRule(rule DeviceRule, options LimitOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.container == nil {
+		return ErrNotDefined
+	}
+
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	running := c.running()
+	if !running && !options.Persist {
+		return fmt.Errorf("%s: %q", ErrNotRunning, c.name())
+	}
+
+	action := deviceRuleAction(rule.Allow)
+
+	// On cgroup v2 liblxc updates the container's device eBPF program.
+	if running {
+		if err := c.setCgroupItem("devices."+action, rule.String()); err != nil {
+			return fmt.Errorf("%s: %s", ErrSettingDeviceRuleFailed, err)
+		}
+	}
+
+	if !options.Persist {
+		return nil
+	}
+
+	// The rules are applied in order on start, so the rule goes last and
+	// replaces any persisted entry for the same devices, e.g. one of the
+	// opposite action which would undo it.
+	var entries [][2]string
+	for _, entry := range c.deviceEntries() {
+		if entry[1] != rule.String() {
+			entries = append(entries, entry)
+		}
+	}
+	entries = append(entries, [2]string{action, rule.String()})
+
+	if err := c.setDeviceEntries(entries); err != nil {
+		return err
+	}
+
+	return c.saveConfigFile(c.configFileName())
+}
+
+// deviceEntries returns the persisted device rules in the order they are
+// applied, as pairs of action and rule.
+//
+// Caller needs to hold the lock
+func (c *Container) deviceEntries() [][2]string {
+	prefix := cgroupConfigPrefix()
+
+	// The keys only list their own entries, the bare prefix lists all the
+	// cgroup entries in order.
+	var entries [][2]string
+	for _, line := range c.configItem(prefix) {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+			continue
+		}
+
+		for _, action := range []string{"allow", "deny"} {
+			if strings.TrimSpace(kv[0]) == prefix+".devices."+action {
+				entries = append(entries, [2]string{action, strings.TrimSpace(kv[1])})
+			}
+		}
+	}
+
+	return entries
+}
+
+// setDeviceEntries replaces the persisted device rules with the entries.
+// Both keys are cleared first as setting a key appends its entry after the
+// entries of the other one.
+//
+// Caller needs to hold the lock
+func (c *Container) setDeviceEntries(entries [][2]string) error {
+	prefix := cgroupConfigPrefix()
+
+	for _, action := range []string{"allow", "deny"} {
+		if err := c.clearConfigItem(prefix + ".devices." + action); err != nil {
+			return fmt.Errorf("%s: %s.devices.%s", err, prefix, action)
+		}
+	}
+
+	for _, entry := range entries {
+		key := prefix + ".devices." + entry[0]
+		if err := c.setConfigItem(key, entry[1]); err != nil {
+			return fmt.Errorf("%s: %s=%q", err, key, entry[1])
+		}
+	}
+
+	return nil
+}
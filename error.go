@@ -32,6 +32,12 @@ const (
 	// ErrBlkioUsage - BlkioUsage for the container failed
 	ErrBlkioUsage = lxcError("BlkioUsage for the container failed")
 
+	// ErrCgroupNotFound - finding the cgroup of the container failed
+	ErrCgroupNotFound = lxcError("finding the cgroup of the container failed")
+
+	// ErrCPULimit - your kernel does not support cgroup cpu controller
+	ErrCPULimit = lxcError("your kernel does not support cgroup cpu controller")
+
 	// ErrCheckpointFailed - checkpoint failed
 	ErrCheckpointFailed = lxcError("checkpoint failed")
 
@@ -77,12 +83,39 @@ const (
 	// ErrFreezeFailed - freezing the container failed
 	ErrFreezeFailed = lxcError("freezing the container failed")
 
+	// ErrHugetlbLimit - your kernel does not support cgroup hugetlb controller
+	ErrHugetlbLimit = lxcError("your kernel does not support cgroup hugetlb controller")
+
+	// ErrImageNotCached - image is not in the download cache
+	ErrImageNotCached = lxcError("image is not in the download cache")
+
 	// ErrInsufficientNumberOfArguments - insufficient number of arguments were supplied
 	ErrInsufficientNumberOfArguments = lxcError("insufficient number of arguments were supplied")
 
 	// ErrInterfaces - getting interface names for the container failed
 	ErrInterfaces = lxcError("getting interface names for the container failed")
 
+	// ErrInvalidArchive - not a container archive
+	ErrInvalidArchive = lxcError("not a container archive")
+
+	// ErrInvalidBlockDevice - not a block device
+	ErrInvalidBlockDevice = lxcError("not a block device")
+
+	// ErrInvalidCPUSet - cpuset list is not valid on this host
+	ErrInvalidCPUSet = lxcError("cpuset list is not valid on this host")
+
+	// ErrInvalidDeviceRule - device rule is not valid
+	ErrInvalidDeviceRule = lxcError("device rule is not valid")
+
+	// ErrInvalidOCIImage - OCI image is not valid
+	ErrInvalidOCIImage = lxcError("OCI image is not valid")
+
+	// ErrInvalidOCISpec - OCI runtime spec is not valid
+	ErrInvalidOCISpec = lxcError("OCI runtime spec is not valid")
+
+	// ErrInvalidRootfsSource - root filesystem source is not valid
+	ErrInvalidRootfsSource = lxcError("root filesystem source is not valid")
+
 	// ErrIPAddresses - getting IP addresses of the container failed
 	ErrIPAddresses = lxcError("getting IP addresses of the container failed")
 
@@ -110,6 +143,9 @@ const (
 	// ErrMethodNotAllowed - the requested method is not currently supported with unprivileged containers
 	ErrMethodNotAllowed = lxcError("the requested method is not currently supported with unprivileged containers")
 
+	// ErrMountFailed - mounting into the container failed
+	ErrMountFailed = lxcError("mounting into the container failed")
+
 	// ErrNewFailed - allocating the container failed
 	ErrNewFailed = lxcError("allocating the container failed")
 
@@ -122,12 +158,21 @@ const (
 	// ErrNotFrozen - container is not frozen
 	ErrNotFrozen = lxcError("container is not frozen")
 
+	// ErrNotRegularFile - not a regular file
+	ErrNotRegularFile = lxcError("not a regular file")
+
 	// ErrNotRunning - container is not running
 	ErrNotRunning = lxcError("container is not running")
 
 	// ErrNotSupported - method is not supported by this LXC version
 	ErrNotSupported = lxcError("method is not supported by this LXC version")
 
+	// ErrPidsLimit - your kernel does not support cgroup pids controller
+	ErrPidsLimit = lxcError("your kernel does not support cgroup pids controller")
+
+	// ErrPressureUnavailable - pressure stall information is not available for the container
+	ErrPressureUnavailable = lxcError("pressure stall information is not available for the container")
+
 	// ErrRebootFailed - rebooting the container failed
 	ErrRebootFailed = lxcError("rebooting the container failed")
 
@@ -137,6 +182,9 @@ const (
 	// ErrRenameFailed - renaming the container failed
 	ErrRenameFailed = lxcError("renaming the container failed")
 
+	// ErrResizeRootfsFailed - resizing the root filesystem of the container failed
+	ErrResizeRootfsFailed = lxcError("resizing the root filesystem of the container failed")
+
 	// ErrRestoreFailed - restore failed
 	ErrRestoreFailed = lxcError("restore failed")
 
@@ -146,6 +194,9 @@ const (
 	// ErrSaveConfigFailed - saving config file for the container failed
 	ErrSaveConfigFailed = lxcError("saving config file for the container failed")
 
+	// ErrSettingCPULimitsFailed - setting CPU limits for the container failed
+	ErrSettingCPULimitsFailed = lxcError("setting CPU limits for the container failed")
+
 	// ErrSettingCgroupItemFailed - setting cgroup item for the container failed
 	ErrSettingCgroupItemFailed = lxcError("setting cgroup item for the container failed")
 
@@ -155,6 +206,15 @@ const (
 	// ErrSettingConfigPathFailed - setting config file for the container failed
 	ErrSettingConfigPathFailed = lxcError("setting config file for the container failed")
 
+	// ErrSettingDeviceRuleFailed - setting device rule for the container failed
+	ErrSettingDeviceRuleFailed = lxcError("setting device rule for the container failed")
+
+	// ErrSettingHugepageLimitFailed - setting hugepage limit for the container failed
+	ErrSettingHugepageLimitFailed = lxcError("setting hugepage limit for the container failed")
+
+	// ErrSettingIOLimitsFailed - setting block I/O limits for the container failed
+	ErrSettingIOLimitsFailed = lxcError("setting block I/O limits for the container failed")
+
 	// ErrSettingKMemoryLimitFailed - setting kernel memory limit for the container failed
 	ErrSettingKMemoryLimitFailed = lxcError("setting kernel memory limit for the container failed")
 
@@ -164,6 +224,9 @@ const (
 	// ErrSettingMemorySwapLimitFailed - setting memory+swap limit for the container failed
 	ErrSettingMemorySwapLimitFailed = lxcError("setting memory+swap limit for the container failed")
 
+	// ErrSettingPidsLimitFailed - setting pids limit for the container failed
+	ErrSettingPidsLimitFailed = lxcError("setting pids limit for the container failed")
+
 	// ErrSettingSoftMemoryLimitFailed - setting soft memory limit for the container failed
 	ErrSettingSoftMemoryLimitFailed = lxcError("setting soft memory limit for the container failed")
 
@@ -176,6 +239,9 @@ const (
 	// ErrStartFailed - starting the container failed
 	ErrStartFailed = lxcError("starting the container failed")
 
+	// ErrStatsIncomplete - some of the statistics of the container could not be read
+	ErrStatsIncomplete = lxcError("some of the statistics of the container could not be read")
+
 	// ErrStopFailed - stopping the container failed
 	ErrStopFailed = lxcError("stopping the container failed")
 
@@ -185,6 +251,15 @@ const (
 	// ErrUnfreezeFailed - unfreezing the container failed
 	ErrUnfreezeFailed = lxcError("unfreezing the container failed")
 
+	// ErrUnmountFailed - unmounting from the container failed
+	ErrUnmountFailed = lxcError("unmounting from the container failed")
+
+	// ErrUnsupportedHugepageSize - hugepage size is not supported by the host
+	ErrUnsupportedHugepageSize = lxcError("hugepage size is not supported by the host")
+
+	// ErrUnsupportedRootfs - root filesystem backend is not supported
+	ErrUnsupportedRootfs = lxcError("root filesystem backend is not supported")
+
 	// ErrUnknownBackendStore - unknown backend type
 	ErrUnknownBackendStore = lxcError("unknown backend type")
 
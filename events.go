@@ -0,0 +1,270 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// MemoryEventCounters represents the memory.events counters of a container.
+// On cgroup v1 only OOM (counted since the watch started) and OOMKill are available.
+type MemoryEventCounters struct {
+	// Low is the number of times the cgroup was reclaimed below its low boundary.
+	Low uint64
+	// High is the number of times the cgroup was throttled above its high boundary.
+	High uint64
+	// Max is the number of times the cgroup was about to go over its limit.
+	Max uint64
+	// OOM is the number of times the cgroup hit its limit and reclaim failed.
+	OOM uint64
+	// OOMKill is the number of processes killed by the OOM killer.
+	OOMKill uint64
+}
+
+func (e MemoryEventCounters) sub(previous MemoryEventCounters) MemoryEventCounters {
+	return MemoryEventCounters{
+		Low:     e.Low - previous.Low,
+		High:    e.High - previous.High,
+		Max:     e.Max - previous.Max,
+		OOM:     e.OOM - previous.OOM,
+		OOMKill: e.OOMKill - previous.OOMKill,
+	}
+}
+
+// MemoryEvent is delivered when the memory event counters of a container change.
+type MemoryEvent struct {
+	Time time.Time
+
+	// Counters holds the current values and Delta the change since the previous event.
+	Counters MemoryEventCounters
+	Delta    MemoryEventCounters
+}
+
+// parseMemoryEvents parses the cgroup v2 memory.events file.
+func parseMemoryEvents(lines []string) (MemoryEventCounters, error) {
+	values, err := parseFlatKeyed(lines)
+	if err != nil {
+		return MemoryEventCounters{}, err
+	}
+
+	return MemoryEventCounters{
+		Low:     values["low"],
+		High:    values["high"],
+		Max:     values["max"],
+		OOM:     values["oom"],
+		OOMKill: values["oom_kill"],
+	}, nil
+}
+
+func readMemoryEvents(path string) (MemoryEventCounters, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return MemoryEventCounters{}, err
+	}
+
+	return parseMemoryEvents(strings.Split(string(content), "\n"))
+}
+
+// MemoryEvents delivers an event on the returned channel every time the
+// memory event counters of the container change. On cgroup v2 all the
+// memory.events counters are watched, on cgroup v1 only OOM notifications
+// through memory.oom_control are available. The channel is closed once the
+// context is done or the container's cgroup goes away.
+func (c *Container) MemoryEvents(ctx context.Context) (<-chan MemoryEvent, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	if cgroup2() {
+		return c.memoryEventsUnified(ctx)
+	}
+
+	return c.memoryEventsLegacy(ctx)
+}
+
+// Caller needs to hold the lock
+func (c *Container) memoryEventsUnified(ctx context.Context) (<-chan MemoryEvent, error) {
+	path, err := c.cgroupPath("")
+	if err != nil {
+		return nil, err
+	}
+	path = filepath.Join(path, "memory.events")
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	// The kernel generates a modify event every time a counter changes.
+	if _, err := unix.InotifyAddWatch(fd, path, unix.IN_MODIFY); err != nil {
+		unix.Close(fd)
+		if err == unix.ENOENT {
+			return nil, ErrMemLimit
+		}
+		return nil, err
+	}
+
+	previous, err := readMemoryEvents(path)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	ch := make(chan MemoryEvent)
+	buf := make([]byte, 4096)
+
+	err = pollFd(ctx, fd, unix.POLLIN, func() bool {
+		n, err := unix.Read(fd, buf)
+		if err != nil && err != unix.EAGAIN {
+			return false
+		}
+
+		// The watch is removed along with the cgroup.
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			if event.Mask&unix.IN_IGNORED != 0 {
+				return false
+			}
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+		}
+
+		counters, err := readMemoryEvents(path)
+		if err != nil {
+			return false
+		}
+
+		if counters == previous {
+			return true
+		}
+
+		event := MemoryEvent{Time: time.Now(), Counters: counters, Delta: counters.sub(previous)}
+		previous = counters
+
+		select {
+		case ch <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() {
+		close(ch)
+	})
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) memoryEventsLegacy(ctx context.Context) (<-chan MemoryEvent, error) {
+	path, err := c.cgroupPath("memory")
+	if err != nil {
+		return nil, err
+	}
+
+	oomControl, err := unix.Open(filepath.Join(path, "memory.oom_control"), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if err == unix.ENOENT {
+			return nil, ErrMemLimit
+		}
+		return nil, err
+	}
+
+	efd, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		unix.Close(oomControl)
+		return nil, err
+	}
+
+	control := fmt.Sprintf("%d %d", efd, oomControl)
+	if err := ioutil.WriteFile(filepath.Join(path, "cgroup.event_control"), []byte(control), 0); err != nil {
+		unix.Close(efd)
+		unix.Close(oomControl)
+		return nil, err
+	}
+
+	readOOMKill := func() (uint64, error) {
+		content, err := ioutil.ReadFile(filepath.Join(path, "memory.oom_control"))
+		if err != nil {
+			return 0, err
+		}
+
+		values, err := parseFlatKeyed(strings.Split(string(content), "\n"))
+		if err != nil {
+			return 0, err
+		}
+		return values["oom_kill"], nil
+	}
+
+	var previous MemoryEventCounters
+	if previous.OOMKill, err = readOOMKill(); err != nil {
+		unix.Close(efd)
+		unix.Close(oomControl)
+		return nil, err
+	}
+
+	ch := make(chan MemoryEvent)
+	buf := make([]byte, 8)
+
+	err = pollFd(ctx, efd, unix.POLLIN, func() bool {
+		_, err := unix.Read(efd, buf)
+		if err == unix.EAGAIN {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+
+		// The counter is in native byte order and adds up the OOMs
+		// since the last read.
+		count := *(*uint64)(unsafe.Pointer(&buf[0]))
+
+		// The eventfd is signalled as well when the cgroup is removed.
+		oomKill, err := readOOMKill()
+		if err != nil {
+			return false
+		}
+
+		counters := previous
+		counters.OOM += count
+		counters.OOMKill = oomKill
+
+		event := MemoryEvent{Time: time.Now(), Counters: counters, Delta: counters.sub(previous)}
+		previous = counters
+
+		select {
+		case ch <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() {
+		unix.Close(oomControl)
+		close(ch)
+	})
+	if err != nil {
+		unix.Close(efd)
+		unix.Close(oomControl)
+		return nil, err
+	}
+
+	return ch, nil
+}
@@ -27,6 +27,12 @@ func main() {
 			log.Printf("Comment path: %s\n", s.CommentPath)
 			log.Printf("Timestamp: %s\n", s.Timestamp)
 			log.Printf("LXC path: %s\n", s.Path)
+			if comment, err := s.Comment(); err == nil && comment != "" {
+				log.Printf("Comment: %s\n", comment)
+			}
+			if size, err := s.Size(); err == nil {
+				log.Printf("Size: %s\n", size)
+			}
 			log.Println()
 		}
 		c[i].Release()
@@ -108,4 +108,10 @@ func main() {
 		log.Fatalf("ERROR: %s\n", err.Error())
 	}
 	log.Printf("InterfaceStats: %v\n", interfaceStats)
+
+	stats, err := c.Stats()
+	if err != nil {
+		log.Fatalf("ERROR: %s\n", err.Error())
+	}
+	log.Printf("Stats: %+v\n", stats)
 }
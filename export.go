@@ -0,0 +1,963 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+// #include <lxc/lxccontainer.h>
+// #include <lxc/version.h>
+// #include "lxc-binding.h"
+import "C"
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/sys/unix"
+)
+
+// exportIndexName is the first entry of a container archive.
+const exportIndexName = "index.json"
+
+// exportIndex describes where an exported container came from, so its paths
+// can be rewritten on import.
+type exportIndex struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	RootfsPath string    `json:"rootfs_path"`
+	Created    time.Time `json:"created"`
+}
+
+// rootfsDirectory returns the directory of a root filesystem given the value
+// of lxc.rootfs.path, for the backends keeping it in a plain directory.
+func rootfsDirectory(rootfs string) (string, error) {
+	backend, source, _, err := parseRootfsPath(rootfs)
+	if err != nil || (backend != Directory && backend != Btrfs) {
+		return "", fmt.Errorf("%s: %q", ErrUnsupportedRootfs, rootfs)
+	}
+
+	return source, nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) rootfsPath() string {
+	key := "lxc.rootfs.path"
+	if !VersionAtLeast(2, 1, 0) {
+		key = "lxc.rootfs"
+	}
+
+	value := c.configItem(key)
+	if len(value) == 0 {
+		return ""
+	}
+	return value[0]
+}
+
+// Export writes an archive of the container's configuration and root
+// filesystem to w. Ownership, permissions, extended attributes (including
+// ACLs) and device nodes are preserved. Block based and layered root
+// filesystems are mounted read-only for the time of the export, so the
+// archive holds the content of the root filesystem whatever its backend.
+func (c *Container) Export(w io.Writer, options ExportOptions) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.container == nil {
+		return ErrNotDefined
+	}
+
+	if err := c.makeSure(isDefined | isNotRunning); err != nil {
+		return err
+	}
+
+	backend, source, _, err := parseRootfsPath(c.rootfsPath())
+	if err != nil {
+		return err
+	}
+
+	rootfs, unmount, err := c.mountRootfs(true)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	// Only a directory is meaningful to rewrite paths pointing into the
+	// root filesystem on import.
+	rootfsPath := ""
+	if backend == Directory || backend == Btrfs {
+		rootfsPath = source
+	}
+
+	var out io.WriteCloser
+	switch options.Compression {
+	case NoCompression:
+		out = nopWriteCloser{w}
+	case GzipCompression:
+		out = gzip.NewWriter(w)
+	case ZstdCompression:
+		if out, err = zstd.NewWriter(w); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown compression %d", options.Compression)
+	}
+
+	// Releases the zstd encoder on failures, closing again is a no-op.
+	defer out.Close()
+
+	tw := tar.NewWriter(out)
+
+	index, err := json.Marshal(exportIndex{
+		Name:       c.name(),
+		Path:       c.configPath(),
+		RootfsPath: rootfsPath,
+		Created:    time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:     exportIndexName,
+		Typeflag: tar.TypeReg,
+		Mode:     0600,
+		Size:     int64(len(index)),
+		ModTime:  time.Now(),
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := tw.Write(index); err != nil {
+		return err
+	}
+
+	archiver := newTarArchiver(tw)
+	containerDir := filepath.Join(c.configPath(), c.name())
+
+	// The storage of the root filesystem is recreated on import, skip it
+	// when it lives in the container's directory (e.g. the loop backend's
+	// rootdev or the overlay backend's upper directory).
+	storage := ""
+	if rel, err := filepath.Rel(containerDir, source); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		storage = strings.SplitN(rel, string(filepath.Separator), 2)[0]
+	}
+
+	// The container's directory holds the config and any other file it
+	// references (e.g. fstab), the rootfs may live elsewhere.
+	err = archiver.add(containerDir, "", func(rel string) bool {
+		if rel == "rootfs" || rel == storage {
+			return true
+		}
+		return rel == "snaps" && !options.IncludeSnapshots
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := archiver.add(rootfs, "rootfs", nil); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// tarArchiver writes directory trees to a tar archive, preserving hard links.
+type tarArchiver struct {
+	tw    *tar.Writer
+	links map[uint64]map[uint64]string
+}
+
+func newTarArchiver(tw *tar.Writer) *tarArchiver {
+	return &tarArchiver{tw: tw, links: make(map[uint64]map[uint64]string)}
+}
+
+// add archives the content of root under the prefix, skipping the top level
+// entries for which skip returns true.
+func (a *tarArchiver) add(root string, prefix string, skip func(rel string) bool) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if skip != nil && rel != "." && skip(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		name := filepath.ToSlash(filepath.Join(prefix, rel))
+		if name == "." {
+			return nil
+		}
+
+		return a.addEntry(path, name, info)
+	})
+}
+
+func (a *tarArchiver) addEntry(path string, name string, info os.FileInfo) error {
+	// Sockets are recreated by whatever created them.
+	if info.Mode()&os.ModeSocket != 0 {
+		return nil
+	}
+
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Format = tar.FormatPAX
+	hdr.Uname = ""
+	hdr.Gname = ""
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		hdr.Uid = int(stat.Uid)
+		hdr.Gid = int(stat.Gid)
+
+		if hdr.Typeflag == tar.TypeChar || hdr.Typeflag == tar.TypeBlock {
+			hdr.Devmajor = int64(unix.Major(uint64(stat.Rdev)))
+			hdr.Devminor = int64(unix.Minor(uint64(stat.Rdev)))
+		}
+
+		if stat.Nlink > 1 && hdr.Typeflag == tar.TypeReg {
+			inodes, ok := a.links[uint64(stat.Dev)]
+			if !ok {
+				inodes = make(map[uint64]string)
+				a.links[uint64(stat.Dev)] = inodes
+			}
+
+			if first, ok := inodes[stat.Ino]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				inodes[stat.Ino] = name
+			}
+		}
+	}
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+
+	if len(xattrs) > 0 {
+		hdr.PAXRecords = make(map[string]string, len(xattrs))
+		for key, value := range xattrs {
+			hdr.PAXRecords["SCHILY.xattr."+key] = value
+		}
+	}
+
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(a.tw, f)
+	return err
+}
+
+// readXattrs returns the extended attributes of path, without following
+// symlinks. POSIX ACLs are stored as system.posix_acl_* attributes.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if err == unix.ENOTSUP {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, key := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if key == "" {
+			continue
+		}
+
+		size, err := unix.Lgetxattr(path, key, nil)
+		if err != nil {
+			if err == unix.ENODATA {
+				continue
+			}
+			return nil, err
+		}
+
+		value := make([]byte, size)
+		if size, err = unix.Lgetxattr(path, key, value); err != nil {
+			return nil, err
+		}
+		xattrs[key] = string(value[:size])
+	}
+
+	return xattrs, nil
+}
+
+// Import recreates a container exported with Export as name in lxcpath and
+// returns it. The compression of the archive is detected automatically and
+// the paths in the configuration are rewritten for the new location. The
+// container is created on the backend selected by options and the root
+// filesystem restored onto it. Overlay and aufs aren't supported as LXC only
+// creates them as snapshots of another container.
+func Import(r io.Reader, name string, lxcpath string, options ImportOptions) (*Container, error) {
+	if _, err := os.Lstat(filepath.Join(lxcpath, name)); err == nil {
+		return nil, fmt.Errorf("%s: %q", ErrAlreadyDefined, name)
+	}
+
+	if err := os.MkdirAll(lxcpath, 0755); err != nil {
+		return nil, err
+	}
+
+	c, err := NewContainer(name, lxcpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.importArchive(r, options); err != nil {
+		c.Release()
+		return nil, err
+	}
+
+	// The configuration was replaced behind liblxc's back.
+	c.Release()
+	return NewContainer(name, lxcpath)
+}
+
+// importArchive creates the container and restores the archive onto it.
+func (c *Container) importArchive(r io.Reader, options ImportOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.makeSure(isNotDefined); err != nil {
+		return err
+	}
+
+	in, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tr := tar.NewReader(in)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != exportIndexName {
+		return ErrInvalidArchive
+	}
+
+	var index exportIndex
+	if err := json.NewDecoder(tr).Decode(&index); err != nil {
+		return fmt.Errorf("%s: %s", ErrInvalidArchive, err)
+	}
+
+	if err := c.createWithoutTemplate(TemplateOptions{Backend: options.Backend, BackendSpecs: options.BackendSpecs}); err != nil {
+		return err
+	}
+
+	if err := c.restoreArchive(tr, index); err != nil {
+		// Destroying removes the container's directory along with the
+		// entries already moved into it, make sure nothing is left even
+		// if it fails.
+		C.go_lxc_destroy(c.container)
+		os.RemoveAll(filepath.Join(c.configPath(), c.name()))
+		return err
+	}
+
+	return nil
+}
+
+// restoreArchive extracts the container's directory of the archive next to
+// the newly created one and its root filesystem onto the new storage, then
+// moves the rewritten configuration and the other files in place.
+//
+// Caller needs to hold the lock
+func (c *Container) restoreArchive(tr *tar.Reader, index exportIndex) error {
+	containerDir := filepath.Join(c.configPath(), c.name())
+	rootfs := c.rootfsPath()
+
+	staging, err := ioutil.TempDir(c.configPath(), "."+c.name()+"-import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	ir := &importReader{tr: tr}
+	if err := extractTar(ir, staging, nil, false); err != nil {
+		return err
+	}
+
+	root, unmount, err := c.mountRootfs(false)
+	if err != nil {
+		return err
+	}
+
+	ir.rootfs = true
+	err = extractTar(ir, root, nil, false)
+	unmount()
+	if err != nil {
+		return err
+	}
+
+	if err := rewriteImportedConfigs(staging, containerDir, index, c.name(), rootfs); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+
+	// The config goes last, the container only gets usable once it's there.
+	for _, entry := range entries {
+		if entry.Name() == "config" {
+			continue
+		}
+
+		target := filepath.Join(containerDir, entry.Name())
+		if _, err := os.Lstat(target); err == nil {
+			return fmt.Errorf("%s: %q conflicts with the new storage", ErrInvalidArchive, entry.Name())
+		}
+
+		if err := os.Rename(filepath.Join(staging, entry.Name()), target); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(filepath.Join(staging, "config"), filepath.Join(containerDir, "config"))
+}
+
+// tarReader is the part of tar.Reader used to extract archives.
+type tarReader interface {
+	io.Reader
+	Next() (*tar.Header, error)
+}
+
+// importReader splits an exported archive into the container's directory,
+// ending at the first entry of the root filesystem, and the root filesystem
+// itself once rootfs is set.
+type importReader struct {
+	tr      *tar.Reader
+	rootfs  bool
+	pending *tar.Header
+}
+
+func (r *importReader) Read(p []byte) (int, error) {
+	return r.tr.Read(p)
+}
+
+func (r *importReader) Next() (*tar.Header, error) {
+	hdr := r.pending
+	r.pending = nil
+
+	if hdr == nil {
+		var err error
+		if hdr, err = r.tr.Next(); err != nil {
+			return nil, err
+		}
+	}
+
+	name, inRootfs := trimRootfs(hdr.Name)
+	if !r.rootfs {
+		if inRootfs {
+			r.pending = hdr
+			return nil, io.EOF
+		}
+		return hdr, nil
+	}
+
+	if !inRootfs {
+		return nil, fmt.Errorf("%s: %q is outside of the root filesystem", ErrInvalidArchive, hdr.Name)
+	}
+	hdr.Name = name
+
+	if hdr.Typeflag == tar.TypeLink {
+		if hdr.Linkname, inRootfs = trimRootfs(hdr.Linkname); !inRootfs {
+			return nil, fmt.Errorf("%s: %q links outside of the root filesystem", ErrInvalidArchive, hdr.Name)
+		}
+	}
+
+	return hdr, nil
+}
+
+// trimRootfs returns name relative to the rootfs entry of an archive and
+// whether it is within it.
+func trimRootfs(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "./")
+	if strings.TrimSuffix(name, "/") == "rootfs" {
+		return ".", true
+	}
+
+	if strings.HasPrefix(name, "rootfs/") {
+		return strings.TrimPrefix(name, "rootfs/"), true
+	}
+
+	return name, false
+}
+
+// decompress detects the compression of r from its magic number.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		decoder, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		decoder, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(decoder), nil
+	}
+
+	return ioutil.NopCloser(br), nil
+}
+
+// securePath joins name to root, refusing names escaping it.
+func securePath(root string, name string) (string, error) {
+	path := filepath.Join(root, filepath.Clean("/"+name))
+	if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: invalid path %q", ErrInvalidArchive, name)
+	}
+	return path, nil
+}
+
+// secureJoin resolves path inside root, following symlinks as if root was
+// the filesystem root so the result can't escape it.
+func secureJoin(root string, path string) (string, error) {
+	var resolved string
+	links := 0
+
+	for path != "" {
+		var part string
+		if i := strings.IndexByte(path, '/'); i >= 0 {
+			part, path = path[:i], path[i+1:]
+		} else {
+			part, path = path, ""
+		}
+
+		if part == "" || part == "." {
+			continue
+		}
+
+		next := filepath.Join("/", resolved, part)
+		if part == ".." {
+			resolved = next
+			continue
+		}
+
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			// Missing components are kept as they are.
+			if os.IsNotExist(err) {
+				resolved = next
+				continue
+			}
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > 255 {
+			return "", unix.ELOOP
+		}
+
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		path = target + "/" + path
+	}
+
+	return filepath.Join(root, filepath.Clean("/"+resolved)), nil
+}
+
+// extractPath returns where the archive entry name is extracted to within
+// root. Symlinks in its parents are resolved inside root, so an archive
+// can't write outside of root through symlinks it or a lower layer created.
+func extractPath(root string, name string) (string, error) {
+	if _, err := securePath(root, name); err != nil {
+		return "", err
+	}
+
+	name = filepath.Clean("/" + name)
+	if name == "/" {
+		return root, nil
+	}
+
+	parent, err := secureJoin(root, filepath.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(name)), nil
+}
+
+// Whiteout files mark removals in OCI image layers.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// applyWhiteout removes what the whiteout at path hides: the content of the
+// parent directory not created by the current layer for opaque whiteouts
+// and the whited out entry otherwise.
+func applyWhiteout(path string, created map[string]bool) error {
+	dir, base := filepath.Split(path)
+
+	if base != whiteoutOpaque {
+		return os.RemoveAll(filepath.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		entryPath := filepath.Join(dir, entry.Name())
+		if created[entryPath] {
+			continue
+		}
+
+		if err := os.RemoveAll(entryPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractTar extracts the archive into root, restoring ownership, extended
+// attributes and device nodes. Ownership is shifted to the host IDs of ids
+// when they are set. With whiteouts set, the archive is applied as an OCI
+// image layer on top of the existing content of root.
+func extractTar(tr tarReader, root string, ids idmap, whiteouts bool) error {
+	type dirTimes struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []dirTimes
+
+	// Opaque whiteouts only hide the content of lower layers.
+	created := make(map[string]bool)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := extractPath(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		if whiteouts && strings.HasPrefix(filepath.Base(path), whiteoutPrefix) {
+			if err := applyWhiteout(path, created); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Parents are always archived first, but be lenient.
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		// Replace whatever is in the way, only directories are merged. This
+		// also keeps the entry from being written through a symlink.
+		if info, err := os.Lstat(path); err == nil && !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+		created[path] = true
+
+		mode := uint32(hdr.Mode & 07777)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(path, 0700); err != nil && !os.IsExist(err) {
+				return err
+			}
+			dirs = append(dirs, dirTimes{path, hdr.ModTime})
+		case tar.TypeReg:
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, err := extractPath(root, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+
+			if err := os.Link(target, path); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeChar, tar.TypeBlock:
+			kind := uint32(unix.S_IFCHR)
+			if hdr.Typeflag == tar.TypeBlock {
+				kind = unix.S_IFBLK
+			}
+
+			dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+			if err := unix.Mknod(path, kind|0600, int(dev)); err != nil {
+				return err
+			}
+		case tar.TypeFifo:
+			if err := unix.Mkfifo(path, 0600); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+
+		uid, gid := ids.toHost("u", hdr.Uid), ids.toHost("g", hdr.Gid)
+		if uid < 0 || gid < 0 {
+			return fmt.Errorf("%q is owned by unmapped IDs %d:%d", hdr.Name, hdr.Uid, hdr.Gid)
+		}
+
+		// chown clears the setuid bits and file capabilities, so it goes first.
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeSymlink {
+			if err := unix.Chmod(path, mode); err != nil {
+				return err
+			}
+		}
+
+		for key, value := range hdr.PAXRecords {
+			if !strings.HasPrefix(key, "SCHILY.xattr.") {
+				continue
+			}
+
+			if err := unix.Lsetxattr(path, strings.TrimPrefix(key, "SCHILY.xattr."), []byte(value), 0); err != nil {
+				return err
+			}
+		}
+
+		if hdr.Typeflag != tar.TypeDir {
+			times := []unix.Timespec{unix.NsecToTimespec(hdr.ModTime.UnixNano()), unix.NsecToTimespec(hdr.ModTime.UnixNano())}
+			if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Extracting the content of a directory updates its modification time.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirs[i].path, dirs[i].modTime, dirs[i].modTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteImportedConfigs updates the container's and its snapshots' configs
+// extracted to dir for the new name, location and root filesystem.
+func rewriteImportedConfigs(dir string, containerDir string, index exportIndex, name string, rootfs string) error {
+	configs := []string{filepath.Join(dir, "config")}
+
+	snapshots, err := filepath.Glob(filepath.Join(dir, "snaps", "*", "config"))
+	if err != nil {
+		return err
+	}
+	configs = append(configs, snapshots...)
+
+	for i, config := range configs {
+		content, err := ioutil.ReadFile(config)
+		if err != nil {
+			if i == 0 && os.IsNotExist(err) {
+				return fmt.Errorf("%s: missing config", ErrInvalidArchive)
+			}
+			return err
+		}
+
+		info, err := os.Stat(config)
+		if err != nil {
+			return err
+		}
+
+		configRootfs := rootfs
+		if i > 0 {
+			configRootfs = ""
+		}
+
+		rewritten := rewriteImportedConfig(string(content), index, containerDir, name, configRootfs)
+		if err := ioutil.WriteFile(config, []byte(rewritten), info.Mode()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteImportedConfig rewrites the paths of the original container in a
+// config to the new container directory. The container's own config, for
+// which rootfs is set, also gets its name and root filesystem updated.
+func rewriteImportedConfig(config string, index exportIndex, containerDir string, name string, rootfs string) string {
+	oldDir := filepath.Join(index.Path, index.Name)
+	main := rootfs != ""
+	rootfsSet := false
+
+	lines := strings.Split(config, "\n")
+	for i, line := range lines {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch {
+		case main && (key == "lxc.rootfs.path" || key == "lxc.rootfs"):
+			value = rootfs
+			rootfsSet = true
+		case main && (key == "lxc.uts.name" || key == "lxc.utsname"):
+			value = name
+		default:
+			if index.RootfsPath != "" && !strings.HasPrefix(index.RootfsPath, oldDir+"/") {
+				value = replacePath(value, index.RootfsPath, filepath.Join(containerDir, "rootfs"))
+			}
+			value = replacePath(value, oldDir, containerDir)
+		}
+
+		lines[i] = fmt.Sprintf("%s = %s", key, value)
+	}
+
+	if main && !rootfsSet {
+		key := "lxc.rootfs.path"
+		if !VersionAtLeast(2, 1, 0) {
+			key = "lxc.rootfs"
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s", key, rootfs))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// replacePath replaces the occurrences of the old path in value, as long as
+// they aren't the prefix of a longer name (e.g. /var/lib/lxc/c1 in
+// /var/lib/lxc/c10).
+func replacePath(value string, old string, new string) string {
+	var b strings.Builder
+
+	for {
+		i := strings.Index(value, old)
+		if i < 0 {
+			b.WriteString(value)
+			return b.String()
+		}
+
+		end := i + len(old)
+		b.WriteString(value[:i])
+		if end == len(value) || strings.ContainsRune("/: ", rune(value[end])) {
+			b.WriteString(new)
+		} else {
+			b.WriteString(old)
+		}
+		value = value[end:]
+	}
+}
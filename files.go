@@ -0,0 +1,520 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+// #include <lxc/lxccontainer.h>
+// #include <lxc/version.h>
+// #include "lxc-binding.h"
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// FileInfo describes a file in a container. UID and GID are the IDs inside
+// the container, -1 if they aren't mapped into it.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	UID     int
+	GID     int
+
+	// LinkTarget is the target of symbolic links.
+	LinkTarget string
+}
+
+// containerRoot gives access to the root filesystem of a container, either
+// through /proc/<pid>/root of its init process or its rootfs directory.
+type containerRoot struct {
+	fd   int
+	path string
+	ids  idmap
+}
+
+// Caller needs to hold the lock
+func (c *Container) openRoot() (*containerRoot, error) {
+	if c.container == nil {
+		return nil, ErrNotDefined
+	}
+
+	if err := c.makeSure(isDefined); err != nil {
+		return nil, err
+	}
+
+	root := &containerRoot{ids: c.idmap()}
+
+	if !c.running() {
+		path, err := rootfsDirectory(c.rootfsPath())
+		if err != nil {
+			return nil, err
+		}
+
+		fd, err := unix.Open(path, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		root.fd = fd
+		root.path = path
+		return root, nil
+	}
+
+	// The pidfd makes sure /proc/<pid> still refers to the container's init
+	// process once its root is opened.
+	pidfd := int(C.go_lxc_init_pidfd(c.container))
+	if pidfd < 0 {
+		return nil, unix.Errno(unix.EBADF)
+	}
+	defer unix.Close(pidfd)
+
+	path := fmt.Sprintf("/proc/%d/root", c.initPid())
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unix.PidfdSendSignal(pidfd, 0, nil, 0); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("%s: %q", ErrNotRunning, c.name())
+	}
+
+	root.fd = fd
+	root.path = path
+	return root, nil
+}
+
+func (r *containerRoot) close() {
+	unix.Close(r.fd)
+}
+
+// open opens path relative to the root. Symlinks are resolved as if the
+// root was the filesystem root so they can't escape it.
+func (r *containerRoot) open(path string, flags int, mode uint32) (int, error) {
+	how := &unix.OpenHow{
+		Flags:   uint64(flags | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	if flags&unix.O_CREAT != 0 {
+		how.Mode = uint64(mode)
+	}
+
+	rel := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	if rel == "" {
+		rel = "."
+	}
+
+	fd, err := unix.Openat2(r.fd, rel, how)
+	if err != unix.ENOSYS {
+		return fd, err
+	}
+
+	// openat2 is only available since Linux 5.6.
+	resolved, err := secureJoin(r.path, path)
+	if err != nil {
+		return -1, err
+	}
+
+	return unix.Open(resolved, flags|unix.O_NOFOLLOW|unix.O_CLOEXEC, mode)
+}
+
+// openParent opens the parent directory of path, returning it along with
+// the last path component.
+func (r *containerRoot) openParent(path string) (int, string, error) {
+	path = filepath.Clean("/" + path)
+	if path == "/" {
+		return -1, "", fmt.Errorf("invalid path %q", path)
+	}
+
+	dir, base := filepath.Split(path)
+	fd, err := r.open(dir, unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return -1, "", err
+	}
+
+	return fd, base, nil
+}
+
+// hostIDs maps the IDs of the options to the host.
+func (r *containerRoot) hostIDs(options FileOptions) (int, int, error) {
+	uid := r.ids.toHost("u", options.UID)
+	gid := r.ids.toHost("g", options.GID)
+	if uid < 0 || gid < 0 {
+		return -1, -1, fmt.Errorf("%d:%d isn't mapped into the container", options.UID, options.GID)
+	}
+
+	return uid, gid, nil
+}
+
+// fileInfo builds the FileInfo of base in the directory dirfd.
+func (r *containerRoot) fileInfo(dirfd int, base string) (FileInfo, error) {
+	var stat unix.Stat_t
+	if err := unix.Fstatat(dirfd, base, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return FileInfo{}, err
+	}
+
+	info := r.statInfo(base, &stat)
+
+	if info.Mode&os.ModeSymlink != 0 {
+		buf := make([]byte, unix.PathMax)
+		n, err := unix.Readlinkat(dirfd, base, buf)
+		if err != nil {
+			return FileInfo{}, err
+		}
+		info.LinkTarget = string(buf[:n])
+	}
+
+	return info, nil
+}
+
+func (r *containerRoot) statInfo(name string, stat *unix.Stat_t) FileInfo {
+	return FileInfo{
+		Name:    name,
+		Size:    stat.Size,
+		Mode:    fileMode(stat.Mode),
+		ModTime: time.Unix(stat.Mtim.Unix()),
+		UID:     r.ids.toContainer("u", int(stat.Uid)),
+		GID:     r.ids.toContainer("g", int(stat.Gid)),
+	}
+}
+
+// fileMode converts a st_mode to an os.FileMode.
+func fileMode(mode uint32) os.FileMode {
+	result := os.FileMode(mode & 0777)
+
+	switch mode & unix.S_IFMT {
+	case unix.S_IFDIR:
+		result |= os.ModeDir
+	case unix.S_IFLNK:
+		result |= os.ModeSymlink
+	case unix.S_IFIFO:
+		result |= os.ModeNamedPipe
+	case unix.S_IFSOCK:
+		result |= os.ModeSocket
+	case unix.S_IFCHR:
+		result |= os.ModeDevice | os.ModeCharDevice
+	case unix.S_IFBLK:
+		result |= os.ModeDevice
+	}
+
+	if mode&unix.S_ISUID != 0 {
+		result |= os.ModeSetuid
+	}
+	if mode&unix.S_ISGID != 0 {
+		result |= os.ModeSetgid
+	}
+	if mode&unix.S_ISVTX != 0 {
+		result |= os.ModeSticky
+	}
+
+	return result
+}
+
+// PushFile writes the content of r to path in the container, replacing any
+// existing file. The parent directory has to exist and path can only be a
+// regular file. Running containers are accessed through their mount
+// namespace, stopped ones through their rootfs.
+func (c *Container) PushFile(path string, r io.Reader, options FileOptions) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	root, err := c.openRoot()
+	if err != nil {
+		return err
+	}
+	defer root.close()
+
+	uid, gid, err := root.hostIDs(options)
+	if err != nil {
+		return err
+	}
+
+	mode := options.Mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+
+	return root.pushFile(path, r, uid, gid, mode)
+}
+
+func (r *containerRoot) pushFile(path string, content io.Reader, uid int, gid int, mode os.FileMode) error {
+	dirfd, base, err := r.openParent(path)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	var f *os.File
+	fd, err := unix.Openat(dirfd, base, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err == nil {
+		f, err = reopenRegular(fd, path, unix.O_WRONLY|unix.O_TRUNC)
+		unix.Close(fd)
+	} else if err == unix.ENOENT {
+		// O_EXCL makes sure nothing was planted in the meantime.
+		fd, err = unix.Openat(dirfd, base, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(mode))
+		if err == nil {
+			f = os.NewFile(uintptr(fd), path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Chown(uid, gid); err != nil {
+		return err
+	}
+
+	// The umask applies to the mode given on creation.
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, content); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// PullFile opens path in the container for reading. Only regular files can
+// be pulled.
+func (c *Container) PullFile(path string) (io.ReadCloser, FileInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	root, err := c.openRoot()
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	defer root.close()
+
+	f, info, err := root.pullFile(path)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+
+	return f, info, nil
+}
+
+func (r *containerRoot) pullFile(path string) (*os.File, FileInfo, error) {
+	fd, err := r.open(path, unix.O_PATH, 0)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	defer unix.Close(fd)
+
+	f, err := reopenRegular(fd, path, unix.O_RDONLY)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &stat); err != nil {
+		f.Close()
+		return nil, FileInfo{}, err
+	}
+
+	return f, r.statInfo(filepath.Base(path), &stat), nil
+}
+
+// reopenRegular opens the file the O_PATH file descriptor refers to if it is
+// a regular file. Opening FIFOs or device nodes planted by the container
+// would block the caller or give access to host devices.
+func reopenRegular(fd int, name string, flags int) (*os.File, error) {
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return nil, err
+	}
+
+	if stat.Mode&unix.S_IFMT != unix.S_IFREG {
+		return nil, fmt.Errorf("%s: %q", ErrNotRegularFile, name)
+	}
+
+	// Reopening through the descriptor opens the very same inode.
+	newfd, err := unix.Open(fmt.Sprintf("/proc/self/fd/%d", fd), flags|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(newfd), name), nil
+}
+
+// Stat returns information about path in the container. Symbolic links
+// aren't followed.
+func (c *Container) Stat(path string) (FileInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	root, err := c.openRoot()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer root.close()
+
+	if filepath.Clean("/"+path) == "/" {
+		var stat unix.Stat_t
+		if err := unix.Fstat(root.fd, &stat); err != nil {
+			return FileInfo{}, err
+		}
+		return root.statInfo("/", &stat), nil
+	}
+
+	dirfd, base, err := root.openParent(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer unix.Close(dirfd)
+
+	return root.fileInfo(dirfd, base)
+}
+
+// ReadDir returns the entries of the directory path in the container, sorted by name.
+func (c *Container) ReadDir(path string) ([]FileInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	root, err := c.openRoot()
+	if err != nil {
+		return nil, err
+	}
+	defer root.close()
+
+	fd, err := root.open(path, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := os.NewFile(uintptr(fd), path)
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	entries := make([]FileInfo, 0, len(names))
+	for _, name := range names {
+		info, err := root.fileInfo(fd, name)
+		if err != nil {
+			// The entry was removed in the meantime.
+			if err == unix.ENOENT {
+				continue
+			}
+			return nil, err
+		}
+		entries = append(entries, info)
+	}
+
+	return entries, nil
+}
+
+// MkdirAll creates the directory path in the container along with any
+// missing parent. The options apply to the directories being created.
+func (c *Container) MkdirAll(path string, options FileOptions) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	root, err := c.openRoot()
+	if err != nil {
+		return err
+	}
+	defer root.close()
+
+	uid, gid, err := root.hostIDs(options)
+	if err != nil {
+		return err
+	}
+
+	mode := options.Mode.Perm()
+	if mode == 0 {
+		mode = 0755
+	}
+
+	current := "/"
+	for _, part := range strings.Split(filepath.Clean("/"+path), "/") {
+		if part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+
+		if err := root.mkdir(current, uint32(mode), uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *containerRoot) mkdir(path string, mode uint32, uid int, gid int) error {
+	dirfd, base, err := r.openParent(path)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	err = unix.Mkdirat(dirfd, base, mode)
+	if err == unix.EEXIST {
+		// Existing symlinks to directories are resolved by the next component.
+		fd, err := r.open(path, unix.O_PATH|unix.O_DIRECTORY, 0)
+		if err != nil {
+			return err
+		}
+		return unix.Close(fd)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := unix.Fchownat(dirfd, base, uid, gid, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return err
+	}
+
+	// The umask applies to the mode given on creation.
+	fd, err := unix.Openat(dirfd, base, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	return unix.Fchmod(fd, mode)
+}
+
+// Remove removes the file or empty directory path in the container.
+func (c *Container) Remove(path string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	root, err := c.openRoot()
+	if err != nil {
+		return err
+	}
+	defer root.close()
+
+	dirfd, base, err := root.openParent(path)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	err = unix.Unlinkat(dirfd, base, 0)
+	if err == unix.EISDIR {
+		err = unix.Unlinkat(dirfd, base, unix.AT_REMOVEDIR)
+	}
+	return err
+}
@@ -3,3 +3,12 @@ module github.com/lxc/go-lxc
 go 1.20
 
 require golang.org/x/sys v0.12.0
+
+require github.com/klauspost/compress v1.17.0
+
+require (
+	github.com/opencontainers/go-digest v1.0.0
+	github.com/opencontainers/image-spec v1.1.0
+	github.com/opencontainers/runtime-spec v1.1.0
+	github.com/ulikunitz/xz v0.5.11
+)
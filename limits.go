@@ -0,0 +1,663 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// CPULimits type is used for defining the CPU limits of a container.
+// Zero values are left unchanged by SetCPULimits.
+type CPULimits struct {
+	// Weight is the relative share of CPU time between 1 and 10000
+	// (cgroup v2 cpu.weight), translated to cpu.shares on cgroup v1.
+	Weight uint64
+
+	// Quota is the CPU time the container can use every Period, negative for no limit.
+	Quota time.Duration
+
+	// Period is the length of the CFS accounting period.
+	Period time.Duration
+
+	// Cpus is the list of CPUs the container can run on (e.g. "0-3,6").
+	Cpus string
+
+	// Mems is the list of memory nodes the container can allocate from.
+	Mems string
+}
+
+// cpuSharesToWeight converts cgroup v1 cpu.shares [2-262144] to cgroup v2 cpu.weight [1-10000].
+func cpuSharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// cpuWeightToShares converts cgroup v2 cpu.weight [1-10000] to cgroup v1 cpu.shares [2-262144].
+func cpuWeightToShares(weight uint64) uint64 {
+	return 2 + ((weight-1)*262142)/9999
+}
+
+func (l CPULimits) validate() error {
+	if l.Weight > 10000 {
+		return fmt.Errorf("CPU weight %d is not between 1 and 10000", l.Weight)
+	}
+
+	if l.Quota > 0 && l.Quota < time.Millisecond {
+		return fmt.Errorf("CPU quota %s is below 1ms", l.Quota)
+	}
+
+	if l.Period < 0 || (l.Period > 0 && (l.Period < time.Millisecond || l.Period > time.Second)) {
+		return fmt.Errorf("CPU period %s is not between 1ms and 1s", l.Period)
+	}
+
+	if l.Cpus != "" {
+		if err := validateCPUList(l.Cpus, "/sys/devices/system/cpu/online"); err != nil {
+			return err
+		}
+	}
+
+	if l.Mems != "" {
+		if err := validateCPUList(l.Mems, "/sys/devices/system/node/online"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateCPUList checks that all the entries of a cpuset list are online on the host.
+func validateCPUList(list string, online string) error {
+	entries, err := parseCPUList(list)
+	if err != nil {
+		return fmt.Errorf("%s: %q", ErrInvalidCPUSet, list)
+	}
+
+	// Hosts without NUMA support don't expose the node directory.
+	available := "0"
+	if content, err := ioutil.ReadFile(online); err == nil {
+		available = string(content)
+	}
+
+	hostEntries, err := parseCPUList(available)
+	if err != nil {
+		return err
+	}
+
+	valid := make(map[int]bool, len(hostEntries))
+	for _, v := range hostEntries {
+		valid[v] = true
+	}
+
+	for _, v := range entries {
+		if !valid[v] {
+			return fmt.Errorf("%s: %q", ErrInvalidCPUSet, list)
+		}
+	}
+
+	return nil
+}
+
+// settings translates the limits into the cgroup files of the host's hierarchy.
+// currentMax is the current content of cpu.max, used on cgroup v2 to change
+// only one of the quota or the period.
+func (l CPULimits) settings(unified bool, currentMax string) []cgroupSetting {
+	var settings []cgroupSetting
+
+	if l.Weight > 0 {
+		if unified {
+			settings = append(settings, cgroupSetting{"cpu.weight", strconv.FormatUint(l.Weight, 10)})
+		} else {
+			settings = append(settings, cgroupSetting{"cpu.shares", strconv.FormatUint(cpuWeightToShares(l.Weight), 10)})
+		}
+	}
+
+	quota := ""
+	if l.Quota < 0 {
+		quota = "max"
+	} else if l.Quota > 0 {
+		quota = strconv.FormatInt(l.Quota.Microseconds(), 10)
+	}
+
+	period := ""
+	if l.Period > 0 {
+		period = strconv.FormatInt(l.Period.Microseconds(), 10)
+	}
+
+	if unified {
+		if quota != "" || period != "" {
+			current := strings.Fields(currentMax)
+			if quota == "" {
+				quota = "max"
+				if len(current) > 0 {
+					quota = current[0]
+				}
+			}
+			if period == "" && len(current) > 1 {
+				period = current[1]
+			}
+
+			settings = append(settings, cgroupSetting{"cpu.max", strings.TrimSpace(quota + " " + period)})
+		}
+	} else {
+		// The period goes first as the kernel checks the quota against the
+		// parent's bandwidth using the current period. The quota may exceed
+		// the period to allow using more than one CPU.
+		if period != "" {
+			settings = append(settings, cgroupSetting{"cpu.cfs_period_us", period})
+		}
+		if quota == "max" {
+			quota = "-1"
+		}
+		if quota != "" {
+			settings = append(settings, cgroupSetting{"cpu.cfs_quota_us", quota})
+		}
+	}
+
+	if l.Cpus != "" {
+		settings = append(settings, cgroupSetting{"cpuset.cpus", l.Cpus})
+	}
+
+	if l.Mems != "" {
+		settings = append(settings, cgroupSetting{"cpuset.mems", l.Mems})
+	}
+
+	return settings
+}
+
+// CPULimits returns the CPU limits of the container.
+// The weight is always reported in the cgroup v2 range and a negative quota means no limit.
+func (c *Container) CPULimits() (*CPULimits, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	limits := &CPULimits{}
+
+	if cgroup2() {
+		weight, err := strconv.ParseUint(c.cgroupValue("cpu.weight"), 10, 64)
+		if err != nil {
+			return nil, ErrCPULimit
+		}
+		limits.Weight = weight
+
+		max := strings.Fields(c.cgroupValue("cpu.max"))
+		if len(max) != 2 {
+			return nil, ErrCPULimit
+		}
+
+		limits.Quota = -1
+		if max[0] != "max" {
+			quota, err := strconv.ParseInt(max[0], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			limits.Quota = time.Duration(quota) * time.Microsecond
+		}
+
+		period, err := strconv.ParseInt(max[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		limits.Period = time.Duration(period) * time.Microsecond
+
+		// Empty cpusets inherit from the parent.
+		limits.Cpus = c.cgroupValue("cpuset.cpus.effective")
+		limits.Mems = c.cgroupValue("cpuset.mems.effective")
+
+		return limits, nil
+	}
+
+	shares, err := strconv.ParseUint(c.cgroupValue("cpu.shares"), 10, 64)
+	if err != nil {
+		return nil, ErrCPULimit
+	}
+	limits.Weight = cpuSharesToWeight(shares)
+
+	quota, err := strconv.ParseInt(c.cgroupValue("cpu.cfs_quota_us"), 10, 64)
+	if err != nil {
+		return nil, ErrCPULimit
+	}
+	limits.Quota = -1
+	if quota >= 0 {
+		limits.Quota = time.Duration(quota) * time.Microsecond
+	}
+
+	period, err := strconv.ParseInt(c.cgroupValue("cpu.cfs_period_us"), 10, 64)
+	if err != nil {
+		return nil, ErrCPULimit
+	}
+	limits.Period = time.Duration(period) * time.Microsecond
+
+	limits.Cpus = c.cgroupValue("cpuset.cpus")
+	limits.Mems = c.cgroupValue("cpuset.mems")
+
+	return limits, nil
+}
+
+// SetCPULimits sets the CPU limits of the container. The limits are applied to
+// the running container and, if requested, saved in its configuration file.
+func (c *Container) SetCPULimits(limits CPULimits, options LimitOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.container == nil {
+		return ErrNotDefined
+	}
+
+	if err := limits.validate(); err != nil {
+		return err
+	}
+
+	running := c.running()
+	if !running && !options.Persist {
+		return fmt.Errorf("%s: %q", ErrNotRunning, c.name())
+	}
+
+	var currentMax string
+	if running {
+		currentMax = c.cgroupValue("cpu.max")
+	}
+	settings := limits.settings(cgroup2(), currentMax)
+
+	if running {
+		if err := c.setCgroupSettings(settings); err != nil {
+			return fmt.Errorf("%s: %s", ErrSettingCPULimitsFailed, err)
+		}
+	}
+
+	if options.Persist {
+		return c.persistCgroupSettings(settings)
+	}
+
+	return nil
+}
+
+// IOLimit type is used for defining the block I/O throttling of a container
+// on a single device. Zero values mean no limit.
+type IOLimit struct {
+	// Device is either the path of a block device or its "major:minor" number.
+	Device string
+
+	ReadBPS   ByteSize
+	WriteBPS  ByteSize
+	ReadIOPS  uint64
+	WriteIOPS uint64
+}
+
+// resolveBlockDevice returns the "major:minor" number of a block device path or number.
+func resolveBlockDevice(device string) (string, error) {
+	if major, minor, err := parseDeviceNumber(device); err == nil {
+		return fmt.Sprintf("%d:%d", major, minor), nil
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Stat(device, &stat); err != nil {
+		return "", fmt.Errorf("%s: %q", ErrInvalidBlockDevice, device)
+	}
+
+	if stat.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return "", fmt.Errorf("%s: %q", ErrInvalidBlockDevice, device)
+	}
+
+	return fmt.Sprintf("%d:%d", unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev))), nil
+}
+
+// blkioThrottleFiles are the cgroup v1 files used for block I/O throttling.
+var blkioThrottleFiles = []string{
+	"blkio.throttle.read_bps_device",
+	"blkio.throttle.write_bps_device",
+	"blkio.throttle.read_iops_device",
+	"blkio.throttle.write_iops_device",
+}
+
+// values returns the limits in the order of blkioThrottleFiles.
+func (l IOLimit) values() []uint64 {
+	return []uint64{uint64(l.ReadBPS), uint64(l.WriteBPS), l.ReadIOPS, l.WriteIOPS}
+}
+
+// ioLimitSettings translates the limits into the cgroup files of the host's
+// hierarchy. Devices have to be resolved to their number already.
+func ioLimitSettings(limits []IOLimit, unified bool) []cgroupSetting {
+	var settings []cgroupSetting
+
+	for _, limit := range limits {
+		if unified {
+			var fields []string
+			for i, key := range []string{"rbps", "wbps", "riops", "wiops"} {
+				value := "max"
+				if v := limit.values()[i]; v > 0 {
+					value = strconv.FormatUint(v, 10)
+				}
+				fields = append(fields, fmt.Sprintf("%s=%s", key, value))
+			}
+
+			settings = append(settings, cgroupSetting{"io.max", fmt.Sprintf("%s %s", limit.Device, strings.Join(fields, " "))})
+			continue
+		}
+
+		// Writing 0 removes the limit.
+		for i, file := range blkioThrottleFiles {
+			settings = append(settings, cgroupSetting{file, fmt.Sprintf("%s %d", limit.Device, limit.values()[i])})
+		}
+	}
+
+	return settings
+}
+
+// parseIOMax parses the cgroup v2 io.max file.
+func parseIOMax(lines []string) ([]IOLimit, error) {
+	max, err := parseNestedKeyed(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make([]IOLimit, 0, len(max))
+	for device, values := range max {
+		limits = append(limits, IOLimit{
+			Device:    device,
+			ReadBPS:   ByteSize(values["rbps"]),
+			WriteBPS:  ByteSize(values["wbps"]),
+			ReadIOPS:  values["riops"],
+			WriteIOPS: values["wiops"],
+		})
+	}
+	sortIOLimits(limits)
+
+	return limits, nil
+}
+
+// parseBlkioThrottleLimits parses the cgroup v1 throttling files, given in the
+// order of blkioThrottleFiles.
+func parseBlkioThrottleLimits(files [][]string) ([]IOLimit, error) {
+	devices := make(map[string]*IOLimit)
+
+	for i, lines := range files {
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("malformed cgroup line %q", line)
+			}
+
+			value, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			limit, ok := devices[fields[0]]
+			if !ok {
+				limit = &IOLimit{Device: fields[0]}
+				devices[fields[0]] = limit
+			}
+
+			switch i {
+			case 0:
+				limit.ReadBPS = ByteSize(value)
+			case 1:
+				limit.WriteBPS = ByteSize(value)
+			case 2:
+				limit.ReadIOPS = value
+			case 3:
+				limit.WriteIOPS = value
+			}
+		}
+	}
+
+	limits := make([]IOLimit, 0, len(devices))
+	for _, limit := range devices {
+		limits = append(limits, *limit)
+	}
+	sortIOLimits(limits)
+
+	return limits, nil
+}
+
+// sortIOLimits sorts the limits by device number.
+func sortIOLimits(limits []IOLimit) {
+	sort.Slice(limits, func(i, j int) bool {
+		imajor, iminor, _ := parseDeviceNumber(limits[i].Device)
+		jmajor, jminor, _ := parseDeviceNumber(limits[j].Device)
+		if imajor != jmajor {
+			return imajor < jmajor
+		}
+		return iminor < jminor
+	})
+}
+
+// IOLimits returns the block I/O limits of the container, devices are reported by number.
+func (c *Container) IOLimits() ([]IOLimit, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	if cgroup2() {
+		return parseIOMax(c.cgroupItem("io.max"))
+	}
+
+	var files [][]string
+	for _, file := range blkioThrottleFiles {
+		files = append(files, c.cgroupItem(file))
+	}
+	return parseBlkioThrottleLimits(files)
+}
+
+// SetIOLimits sets the block I/O limits of the container for the given
+// devices, replacing their previous limits. The limits are applied to the
+// running container and, if requested, saved in its configuration file.
+func (c *Container) SetIOLimits(limits []IOLimit, options LimitOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.container == nil {
+		return ErrNotDefined
+	}
+
+	resolved := make([]IOLimit, len(limits))
+	for i, limit := range limits {
+		device, err := resolveBlockDevice(limit.Device)
+		if err != nil {
+			return err
+		}
+		limit.Device = device
+		resolved[i] = limit
+	}
+
+	running := c.running()
+	if !running && !options.Persist {
+		return fmt.Errorf("%s: %q", ErrNotRunning, c.name())
+	}
+
+	settings := ioLimitSettings(resolved, cgroup2())
+
+	if running {
+		if err := c.setCgroupSettings(settings); err != nil {
+			return fmt.Errorf("%s: %s", ErrSettingIOLimitsFailed, err)
+		}
+	}
+
+	if options.Persist {
+		return c.persistDeviceCgroupSettings(settings)
+	}
+
+	return nil
+}
+
+// hugepagesPath is where the kernel lists the supported hugepage sizes.
+const hugepagesPath = "/sys/kernel/mm/hugepages"
+
+// HugepageSizes returns the hugepage sizes supported by the host.
+func HugepageSizes() ([]ByteSize, error) {
+	entries, err := ioutil.ReadDir(hugepagesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var sizes []ByteSize
+	for _, entry := range entries {
+		size, err := parseHugepageDir(entry.Name())
+		if err != nil {
+			continue
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	return sizes, nil
+}
+
+// parseHugepageDir parses the "hugepages-<size>kB" directory names of /sys/kernel/mm/hugepages.
+func parseHugepageDir(name string) (ByteSize, error) {
+	if !strings.HasPrefix(name, "hugepages-") || !strings.HasSuffix(name, "kB") {
+		return 0, fmt.Errorf("malformed hugepage directory %q", name)
+	}
+
+	size, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "hugepages-"), "kB"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return ByteSize(size) * KB, nil
+}
+
+// hugetlbName returns the name the hugetlb controller uses for a page size (e.g. "2MB").
+func hugetlbName(pageSize ByteSize) string {
+	switch {
+	case pageSize >= GB:
+		return fmt.Sprintf("%.fGB", pageSize/GB)
+	case pageSize >= MB:
+		return fmt.Sprintf("%.fMB", pageSize/MB)
+	}
+	return fmt.Sprintf("%.fKB", pageSize/KB)
+}
+
+func validateHugepageSize(pageSize ByteSize) error {
+	sizes, err := HugepageSizes()
+	if err != nil {
+		return ErrHugetlbLimit
+	}
+
+	for _, size := range sizes {
+		if size == pageSize {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %s", ErrUnsupportedHugepageSize, pageSize)
+}
+
+// HugepageUsage returns the hugepage usage of the container in bytes for every
+// page size supported by the host.
+func (c *Container) HugepageUsage() (map[ByteSize]ByteSize, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	sizes, err := HugepageSizes()
+	if err != nil {
+		return nil, ErrHugetlbLimit
+	}
+
+	suffix := "usage_in_bytes"
+	if cgroup2() {
+		suffix = "current"
+	}
+
+	usage := make(map[ByteSize]ByteSize)
+	for _, size := range sizes {
+		value, err := c.cgroupItemAsByteSize(fmt.Sprintf("hugetlb.%s.%s", hugetlbName(size), suffix), ErrHugetlbLimit)
+		if err != nil {
+			return nil, err
+		}
+		usage[size] = value
+	}
+
+	return usage, nil
+}
+
+// HugepageLimit returns the hugepage limit of the container in bytes for the
+// given page size, -1 if unlimited.
+func (c *Container) HugepageLimit(pageSize ByteSize) (ByteSize, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return -1, err
+	}
+
+	if err := validateHugepageSize(pageSize); err != nil {
+		return -1, err
+	}
+
+	suffix := "limit_in_bytes"
+	if cgroup2() {
+		suffix = "max"
+	}
+
+	limit, err := parseCgroupLimit(c.cgroupValue(fmt.Sprintf("hugetlb.%s.%s", hugetlbName(pageSize), suffix)))
+	if err != nil {
+		return -1, ErrHugetlbLimit
+	}
+	return ByteSize(limit), nil
+}
+
+// SetHugepageLimit sets the hugepage limit of the container in bytes for the
+// given page size, a negative limit removes it.
+func (c *Container) SetHugepageLimit(pageSize ByteSize, limit ByteSize) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return err
+	}
+
+	if err := validateHugepageSize(pageSize); err != nil {
+		return err
+	}
+
+	if cgroup2() {
+		key := fmt.Sprintf("hugetlb.%s.max", hugetlbName(pageSize))
+		if limit < 0 {
+			if err := c.setCgroupItem(key, "max"); err != nil {
+				return ErrSettingHugepageLimitFailed
+			}
+			return nil
+		}
+		return c.setCgroupItemWithByteSize(key, limit, ErrSettingHugepageLimitFailed)
+	}
+
+	key := fmt.Sprintf("hugetlb.%s.limit_in_bytes", hugetlbName(pageSize))
+	if limit < 0 {
+		if err := c.setCgroupItem(key, "-1"); err != nil {
+			return ErrSettingHugepageLimitFailed
+		}
+		return nil
+	}
+	return c.setCgroupItemWithByteSize(key, limit, ErrSettingHugepageLimitFailed)
+}
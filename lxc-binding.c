@@ -4,12 +4,19 @@
 
 // +build linux,cgo
 
+#ifndef _GNU_SOURCE
+#define _GNU_SOURCE 1
+#endif
 #include <errno.h>
+#include <fcntl.h>
+#include <sched.h>
 #include <stdbool.h>
 #include <string.h>
+#include <sys/mount.h>
+#include <sys/syscall.h>
 #include <sys/types.h>
 #include <sys/wait.h>
-#include <errno.h>
+#include <unistd.h>
 
 #include <lxc/lxccontainer.h>
 #include <lxc/attach_options.h>
@@ -244,13 +251,8 @@ bool go_lxc_save_config(struct lxc_container *c, const char *alt_file) {
 	return c->save_config(c, alt_file);
 }
 
-bool go_lxc_clone(struct lxc_container *c, const char *newname, const char *lxcpath, int flags, const char *bdevtype) {
-	struct lxc_container *c2 = c->clone(c, newname, lxcpath, flags, bdevtype, NULL, 0, NULL);
-	if (c2 == NULL) {
-		return false;
-	}
-	lxc_container_put(c2);
-	return true;
+struct lxc_container *go_lxc_clone(struct lxc_container *c, const char *newname, const char *lxcpath, int flags, const char *bdevtype, const char *bdevdata, uint64_t newsize, char **hookargs) {
+	return c->clone(c, newname, lxcpath, flags, bdevtype, bdevdata, newsize, hookargs);
 }
 
 int go_lxc_console_getfd(struct lxc_container *c, int ttynum) {
@@ -296,6 +298,78 @@ again:
         return status;
 }
 
+int go_lxc_mount(struct lxc_container *c, const char *source, const char *target, const char *fstype, unsigned long flags, const char *data) {
+#if VERSION_AT_LEAST(3, 1, 0)
+	struct lxc_mount mnt = { .version = LXC_MOUNT_API_V1 };
+
+	if (c->mount(c, source, target, fstype, flags, data, &mnt) < 0)
+		return ret_errno(errno ? errno : EINVAL);
+
+	return 0;
+#else
+	return ret_errno(ENOSYS);
+#endif
+}
+
+int go_lxc_umount(struct lxc_container *c, const char *target, unsigned long flags) {
+#if VERSION_AT_LEAST(3, 1, 0)
+	struct lxc_mount mnt = { .version = LXC_MOUNT_API_V1 };
+
+	if (c->umount(c, target, flags, &mnt) < 0)
+		return ret_errno(errno ? errno : EINVAL);
+
+	return 0;
+#else
+	return ret_errno(ENOSYS);
+#endif
+}
+
+/* go_lxc_mount_ns runs mount(2), or move_mount(2) of tree_fd if it isn't
+ * negative, in the mount namespace referred to by ns_fd. An unmount is done
+ * instead when unmount is set. Setting the mount namespace of a multi-threaded
+ * process fails, hence this happens in a forked child.
+ */
+int go_lxc_mount_ns(int ns_fd, int tree_fd, const char *source, const char *target, const char *fstype, unsigned long flags, const char *data, bool unmount) {
+	pid_t pid;
+	int status;
+
+	pid = fork();
+	if (pid < 0)
+		return ret_errno(errno);
+
+	if (pid == 0) {
+		int ret;
+
+		if (setns(ns_fd, CLONE_NEWNS) < 0)
+			_exit(errno);
+
+		if (unmount)
+			ret = umount2(target, (int)flags);
+		else if (tree_fd >= 0)
+#ifdef SYS_move_mount
+			ret = syscall(SYS_move_mount, tree_fd, "", AT_FDCWD, target, 0x00000004 /* MOVE_MOUNT_F_EMPTY_PATH */);
+#else
+			ret = ret_errno(ENOSYS);
+#endif
+		else
+			ret = mount(source, target, fstype, flags, data);
+
+		_exit(ret < 0 ? errno : 0);
+	}
+
+	status = wait_for_pid_status(pid);
+	if (status < 0)
+		return ret_errno(errno);
+
+	if (!WIFEXITED(status))
+		return ret_errno(EINTR);
+
+	if (WEXITSTATUS(status) != 0)
+		return ret_errno(WEXITSTATUS(status));
+
+	return 0;
+}
+
 int go_lxc_attach_no_wait(struct lxc_container *c,
 		bool clear_env,
 		int namespaces,
@@ -456,8 +530,8 @@ bool go_lxc_may_control(struct lxc_container *c) {
 	return c->may_control(c);
 }
 
-int go_lxc_snapshot(struct lxc_container *c) {
-	return c->snapshot(c, NULL);
+int go_lxc_snapshot(struct lxc_container *c, const char *commentfile) {
+	return c->snapshot(c, commentfile);
 }
 
 int go_lxc_snapshot_list(struct lxc_container *c, struct lxc_snapshot **ret) {
@@ -12,7 +12,7 @@
 extern bool go_lxc_add_device_node(struct lxc_container *c, const char *src_path, const char *dest_path);
 extern void go_lxc_clear_config(struct lxc_container *c);
 extern bool go_lxc_clear_config_item(struct lxc_container *c, const char *key);
-extern bool go_lxc_clone(struct lxc_container *c, const char *newname, const char *lxcpath, int flags, const char *bdevtype);
+extern struct lxc_container *go_lxc_clone(struct lxc_container *c, const char *newname, const char *lxcpath, int flags, const char *bdevtype, const char *bdevdata, uint64_t newsize, char **hookargs);
 extern bool go_lxc_console(struct lxc_container *c, int ttynum, int stdinfd, int stdoutfd, int stderrfd, int escape);
 extern bool go_lxc_create(struct lxc_container *c, const char *t, const char *bdevtype, struct bdev_specs *specs, int flags, char * const argv[]);
 extern bool go_lxc_defined(struct lxc_container *c);
@@ -91,12 +91,15 @@ extern int go_lxc_attach_no_wait(struct lxc_container *c,
 		int attach_flags);
 extern int go_lxc_console_getfd(struct lxc_container *c, int ttynum);
 extern int go_lxc_snapshot_list(struct lxc_container *c, struct lxc_snapshot **ret);
-extern int go_lxc_snapshot(struct lxc_container *c);
+extern int go_lxc_snapshot(struct lxc_container *c, const char *commentfile);
 extern pid_t go_lxc_init_pid(struct lxc_container *c);
 extern int go_lxc_init_pidfd(struct lxc_container *c);
 extern int go_lxc_devpts_fd(struct lxc_container *c);
 extern int go_lxc_seccomp_notify_fd(struct lxc_container *c);
 extern int go_lxc_seccomp_notify_fd_active(struct lxc_container *c);
+extern int go_lxc_mount(struct lxc_container *c, const char *source, const char *target, const char *fstype, unsigned long flags, const char *data);
+extern int go_lxc_umount(struct lxc_container *c, const char *target, unsigned long flags);
+extern int go_lxc_mount_ns(int ns_fd, int tree_fd, const char *source, const char *target, const char *fstype, unsigned long flags, const char *data, bool unmount);
 extern int go_lxc_set_timeout(struct lxc_container *c, int timeout);
 extern bool go_lxc_checkpoint(struct lxc_container *c, char *directory, bool stop, bool verbose);
 extern bool go_lxc_restore(struct lxc_container *c, char *directory, bool verbose);
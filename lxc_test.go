@@ -8,11 +8,18 @@
 package lxc
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -20,6 +27,12 @@ import (
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/ulikunitz/xz"
 )
 
 const (
@@ -249,6 +262,151 @@ func TestCloneUsingOverlayfs(t *testing.T) {
 	}
 }
 
+func TestCloneContainer(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	clone, err := c.CloneContainer(fmt.Sprintf("%s-container", ContainerCloneName()), CloneOptions{
+		Backend:       Directory,
+		MaybeSnapshot: true,
+		HookArgs:      []string{"go-lxc"},
+	})
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	defer clone.Release()
+
+	if !clone.Defined() {
+		t.Errorf("CloneContainer failed to define the clone...")
+	}
+
+	if err := clone.Destroy(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestCreateFromRootfs(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	storage, err := c.Storage()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	name := fmt.Sprintf("%s-rootfs", ContainerName())
+	n, err := NewContainer(name)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	defer n.Release()
+
+	if err := n.CreateFromRootfs(TemplateOptions{}, RootfsSource{}); err == nil {
+		t.Errorf("expected an empty source to be refused")
+	}
+
+	if err := n.CreateFromRootfs(TemplateOptions{Backend: Directory}, RootfsSource{Dir: storage.Source}); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	if !n.Defined() {
+		t.Errorf("CreateFromRootfs failed to define the container...")
+	}
+
+	if _, err := n.Stat("/etc"); err != nil {
+		t.Errorf("expected the root filesystem to be populated: %s", err)
+	}
+
+	if err := n.Destroy(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestCreateFromOCI(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	storage, err := c.Storage()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	if err := newTarArchiver(tw).add(storage.Source, "", nil); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	tw.Close()
+
+	layout, err := ioutil.TempDir("", "go-lxc-oci")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(layout)
+
+	writeOCILayout(t, layout, "latest", ocispec.ImageConfig{
+		Cmd:        []string{"/bin/sleep", "infinity"},
+		Env:        []string{"GO_LXC=1"},
+		WorkingDir: "/tmp",
+	}, layer.Bytes())
+
+	oci, err := CreateFromOCI(fmt.Sprintf("%s-oci", ContainerName()), c.ConfigPath(), layout, "latest", OCIOptions{})
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	defer oci.Release()
+
+	if cmd := oci.ConfigItem("lxc.execute.cmd"); cmd[0] != "/bin/sleep infinity" {
+		t.Errorf("unexpected lxc.execute.cmd %q", cmd)
+	}
+
+	if env := oci.ConfigItem("lxc.environment"); env[0] != "GO_LXC=1" {
+		t.Errorf("unexpected lxc.environment %q", env)
+	}
+
+	ociStorage, err := oci.Storage()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	if _, err := oci.ApplyOCISpec(&specs.Spec{
+		Root:     &specs.Root{Path: ociStorage.Source},
+		Hostname: "go-lxc-oci",
+		Process:  &specs.Process{Args: []string{"/bin/true"}, Env: []string{"GO_LXC=2"}},
+	}, layout); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if env := oci.ConfigItem("lxc.environment"); len(env) != 1 || env[0] != "GO_LXC=2" {
+		t.Errorf("unexpected lxc.environment %q", env)
+	}
+
+	if name := oci.ConfigItem("lxc.uts.name"); len(name) != 1 || name[0] != "go-lxc-oci" {
+		t.Errorf("unexpected lxc.uts.name %q", name)
+	}
+
+	if err := oci.Destroy(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
 func TestCreateSnapshot(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
@@ -275,6 +433,36 @@ func TestCreateSnapshots(t *testing.T) {
 	}
 }
 
+func TestCreateSnapshotWithOptions(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	snapshot, err := c.CreateSnapshotWithOptions(SnapshotOptions{Comment: "go-lxc"})
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	comment, err := snapshot.Comment()
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if comment != "go-lxc" {
+		t.Errorf("CreateSnapshotWithOptions failed to store the comment, got %q...", comment)
+	}
+
+	if snapshot.Time.IsZero() {
+		t.Errorf("CreateSnapshotWithOptions failed to parse the timestamp...")
+	}
+
+	if _, err := snapshot.Size(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
 func TestRestoreSnapshot(t *testing.T) {
 	if os.Getenv("GITHUB_ACTION") != "" {
 		t.Skip("Test broken on Github")
@@ -333,6 +521,68 @@ func TestSnapshots(t *testing.T) {
 	}
 }
 
+func TestSnapshotsToPrune(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	snapshots, err := c.SnapshotsToPrune(RetentionPolicy{KeepLast: 1})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Name == "" {
+			t.Errorf("SnapshotsToPrune returned an unnamed snapshot...")
+		}
+	}
+}
+
+func TestStorage(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	storage, err := c.Storage()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	if storage.Source == "" {
+		t.Errorf("Storage failed to find the root filesystem...")
+	}
+}
+
+func TestResizeRootfs(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	storage, err := c.Storage()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	if storage.Backend != LVM && storage.Backend != Loopback {
+		if err := c.ResizeRootfs(GB); err != ErrNotSupported {
+			t.Errorf("expected ErrNotSupported, got %v", err)
+		}
+		return
+	}
+
+	if err := c.ResizeRootfs(storage.Size + 64*MB); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
 func TestConcurrentStart(t *testing.T) {
 	t.Skip("Skipping concurrent tests for now")
 
@@ -791,6 +1041,24 @@ func TestInterfaceStats(t *testing.T) {
 	}
 }
 
+func TestNetworkInterfaceStats(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	stats, err := c.NetworkInterfaceStats()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	if _, ok := stats["lo"]; !ok {
+		t.Errorf("NetworkInterfaceStats failed to report the loopback interface...")
+	}
+}
+
 func TestMemoryUsage(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
@@ -1061,651 +1329,2890 @@ func TestSetMemorySwapLimit(t *testing.T) {
 	}
 }
 
-func TestCPUTime(t *testing.T) {
+func TestPidsCurrent(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	if _, err := c.CPUTime(); err != nil {
+	if _, err := c.PidsCurrent(); err != nil {
+		if err == ErrPidsLimit {
+			t.Skip("Skipping test due to kernel support")
+			return
+		}
+
 		t.Errorf(err.Error())
 	}
 }
 
-func TestCPUTimePerCPU(t *testing.T) {
+func TestSetPidsLimit(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	if _, err := c.CPUTimePerCPU(); err != nil {
+	if _, err := c.PidsLimit(); err == ErrPidsLimit {
+		t.Skip("Skipping test due to kernel support")
+		return
+	}
+
+	if err := c.SetPidsLimit(1024); err != nil {
 		t.Errorf(err.Error())
 	}
-}
 
-func TestCPUStats(t *testing.T) {
-	c, err := NewContainer(ContainerName())
+	limit, err := c.PidsLimit()
 	if err != nil {
 		t.Errorf(err.Error())
 	}
-	defer c.Release()
+	if limit != 1024 {
+		t.Errorf("SetPidsLimit failed")
+	}
 
-	if _, err := c.CPUStats(); err != nil {
+	if err := c.SetPidsLimit(-1); err != nil {
 		t.Errorf(err.Error())
 	}
-}
 
-func TestRunCommandNoWait(t *testing.T) {
-	c, err := NewContainer("TestRunCommandNoWait")
+	limit, err = c.PidsLimit()
 	if err != nil {
 		t.Errorf(err.Error())
-		t.FailNow()
 	}
-	defer c.Release()
+	if limit != -1 {
+		t.Errorf("SetPidsLimit failed to remove the limit")
+	}
 
-	if err := c.Create(template()); err != nil {
+	if _, err := c.PidsLimitHits(); err != nil {
 		t.Errorf(err.Error())
-		t.FailNow()
 	}
-	defer c.Destroy()
+}
 
-	err = c.Start()
+func TestSetCPULimits(t *testing.T) {
+	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
-		t.FailNow()
 	}
-	defer c.Stop()
+	defer c.Release()
 
-	argsThree := []string{"/bin/sh", "-c", "exit 0"}
-	pid, err := c.RunCommandNoWait(argsThree, DefaultAttachOptions)
+	limits, err := c.CPULimits()
 	if err != nil {
+		if err == ErrCPULimit {
+			t.Skip("Skipping test due to kernel support")
+			return
+		}
+
 		t.Errorf(err.Error())
-		t.FailNow()
+		return
 	}
 
-	proc, err := os.FindProcess(pid)
-	if err != nil {
+	if err := c.SetCPULimits(CPULimits{Quota: limits.Period / 2}, LimitOptions{}); err != nil {
 		t.Errorf(err.Error())
-		t.FailNow()
 	}
 
-	procState, err := proc.Wait()
+	newLimits, err := c.CPULimits()
 	if err != nil {
 		t.Errorf(err.Error())
-		t.FailNow()
 	}
-	if !procState.Success() {
-		t.Errorf("Expected success")
-		t.FailNow()
+	if newLimits.Quota != limits.Period/2 {
+		t.Errorf("SetCPULimits failed")
 	}
 
-	argsThree = []string{"/bin/sh", "-c", "exit 1"}
-	pid, err = c.RunCommandNoWait(argsThree, DefaultAttachOptions)
-	if err != nil {
+	if err := c.SetCPULimits(CPULimits{Quota: -1}, LimitOptions{}); err != nil {
 		t.Errorf(err.Error())
-		t.FailNow()
 	}
+}
 
-	proc, err = os.FindProcess(pid)
+func TestSetIOLimits(t *testing.T) {
+	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
-		t.FailNow()
 	}
+	defer c.Release()
+
+	devices, err := c.BlockIOStats()
+	if err != nil || len(devices) == 0 {
+		t.Skip("Skipping test as the container did not use any block device")
+		return
+	}
+	device := fmt.Sprintf("%d:%d", devices[0].Major, devices[0].Minor)
 
-	procState, err = proc.Wait()
+	if err := c.SetIOLimits([]IOLimit{{Device: device, ReadBPS: 10 * MB}}, LimitOptions{}); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	limits, err := c.IOLimits()
 	if err != nil {
 		t.Errorf(err.Error())
-		t.FailNow()
 	}
 
-	if procState.Success() {
-		t.Errorf("Expected failure")
-		t.FailNow()
+	found := false
+	for _, limit := range limits {
+		if limit.Device == device && limit.ReadBPS == 10*MB {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("SetIOLimits failed")
+	}
+
+	if err := c.SetIOLimits([]IOLimit{{Device: device}}, LimitOptions{}); err != nil {
+		t.Errorf(err.Error())
 	}
 }
 
-func TestRunCommand(t *testing.T) {
+func TestSetHugepageLimit(t *testing.T) {
+	sizes, err := HugepageSizes()
+	if err != nil || len(sizes) == 0 {
+		t.Skip("Skipping test as the host does not support hugepages")
+		return
+	}
+
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	argsThree := []string{"/bin/sh", "-c", "exit 0"}
-	ok, err := c.RunCommand(argsThree, DefaultAttachOptions)
-	if err != nil {
+	if _, err := c.HugepageUsage(); err != nil {
+		if err == ErrHugetlbLimit {
+			t.Skip("Skipping test due to kernel support")
+			return
+		}
+
 		t.Errorf(err.Error())
 	}
-	if !ok {
-		t.Errorf("Expected success")
+
+	if err := c.SetHugepageLimit(sizes[0], sizes[0]*4); err != nil {
+		t.Errorf(err.Error())
 	}
 
-	argsThree = []string{"/bin/sh", "-c", "exit 1"}
-	ok, err = c.RunCommand(argsThree, DefaultAttachOptions)
+	limit, err := c.HugepageLimit(sizes[0])
 	if err != nil {
 		t.Errorf(err.Error())
 	}
-	if ok {
-		t.Errorf("Expected failure")
+	if limit != sizes[0]*4 {
+		t.Errorf("SetHugepageLimit failed")
+	}
+
+	if err := c.SetHugepageLimit(sizes[0], -1); err != nil {
+		t.Errorf(err.Error())
 	}
 }
 
-func TestCommandWithEnv(t *testing.T) {
+func TestMemoryEvents(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	options := DefaultAttachOptions
-	options.Env = []string{"FOO=BAR"}
-	options.ClearEnv = true
-
-	args := []string{"/bin/sh", "-c", "test $FOO = 'BAR'"}
-	ok, err := c.RunCommand(args, options)
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := c.MemoryEvents(ctx)
 	if err != nil {
+		if err == ErrMemLimit || err == ErrCgroupNotFound {
+			t.Skip("Skipping test due to kernel support")
+			return
+		}
+
 		t.Errorf(err.Error())
+		cancel()
+		return
 	}
-	if !ok {
-		t.Errorf("Expected success")
+	cancel()
+
+	// The channel has to be closed once the context is done.
+	for range events {
 	}
 }
 
-func TestCommandWithEnvToKeep(t *testing.T) {
+func TestCPUTime(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	options := DefaultAttachOptions
-	options.ClearEnv = true
-	options.EnvToKeep = []string{"USER"}
-
-	args := []string{"/bin/sh", "-c", fmt.Sprintf("test $USER = '%s'", os.Getenv("USER"))}
-	ok, err := c.RunCommand(args, DefaultAttachOptions)
-	if err != nil {
+	if _, err := c.CPUTime(); err != nil {
 		t.Errorf(err.Error())
 	}
-	if !ok {
-		t.Errorf("Expected success")
-	}
 }
 
-func TestCommandWithCwd(t *testing.T) {
+func TestCPUTimePerCPU(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	options := DefaultAttachOptions
-	options.Cwd = "/tmp"
+	if _, err := c.CPUTimePerCPU(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
 
-	args := []string{"/bin/sh", "-c", "test `pwd` = /tmp"}
-	ok, err := c.RunCommand(args, options)
+func TestCPUStats(t *testing.T) {
+	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
-	if !ok {
-		t.Errorf("Expected success")
+	defer c.Release()
+
+	if _, err := c.CPUStats(); err != nil {
+		t.Errorf(err.Error())
 	}
 }
 
-func TestCommandWithUIDGID(t *testing.T) {
+func TestStats(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	options := DefaultAttachOptions
-	options.UID = 1000
-	options.GID = 1000
-
-	args := []string{"/bin/sh", "-c", "test `id -u` = 1000 && test `id -g` = 1000"}
-	ok, err := c.RunCommand(args, options)
+	stats, err := c.Stats()
 	if err != nil {
 		t.Errorf(err.Error())
+		return
 	}
-	if !ok {
-		t.Errorf("Expected success")
+
+	if stats.Time.IsZero() {
+		t.Errorf("Stats failed to set the timestamp...")
 	}
-}
 
-func TestCommandWithArch(t *testing.T) {
-	uname := syscall.Utsname{}
-	if err := syscall.Uname(&uname); err != nil {
-		t.Errorf(err.Error())
+	if stats.Memory.Usage <= 0 {
+		t.Errorf("Stats reported no memory usage...")
 	}
 
-	arch := ""
-	for _, c := range uname.Machine {
-		if c == 0 {
-			break
-		}
-		arch += string(byte(c))
+	if stats.CPU.Usage <= 0 {
+		t.Errorf("Stats reported no CPU usage...")
 	}
 
-	if arch != "x86_64" && arch != "i686" {
-		t.Skip("skipping architecture test, not on x86")
+	// The pids controller isn't always mounted on cgroup v1.
+	if c.CgroupItem("pids.current")[0] != "" && stats.Pids.Current < 1 {
+		t.Errorf("Stats reported %d tasks in a running container...", stats.Pids.Current)
+	}
+
+	if _, ok := stats.Network["lo"]; !ok {
+		t.Errorf("Stats failed to report the loopback interface...")
 	}
+}
 
+func TestBlockIOStats(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	options := DefaultAttachOptions
-	options.Arch = X86
+	// Direct I/O is accounted to the container's cgroup on v1 as well.
+	args := []string{"/bin/sh", "-c", "dd if=/dev/zero of=/var/tmp/go-lxc-blkio bs=64k count=16 oflag=direct && rm -f /var/tmp/go-lxc-blkio"}
+	if ok, err := c.RunCommand(args, DefaultAttachOptions); err != nil || !ok {
+		t.Errorf("writing to the container's root filesystem failed (%v)", err)
+		return
+	}
 
-	args := []string{"/bin/sh", "-c", "test `uname -m` = i686"}
-	ok, err := c.RunCommand(args, options)
+	devices, err := c.BlockIOStats()
 	if err != nil {
 		t.Errorf(err.Error())
+		return
 	}
-	if !ok {
-		t.Errorf("Expected success")
+
+	var written ByteSize
+	for _, device := range devices {
+		if device.Name == "" {
+			t.Errorf("BlockIOStats failed to resolve the name of %d:%d...", device.Major, device.Minor)
+		}
+		written += device.WriteBytes
+	}
+
+	if written < 16*64*KB {
+		t.Errorf("BlockIOStats reported %s written, expected at least %s", written, 16*64*KB)
 	}
 }
 
-func TestConsoleFd(t *testing.T) {
+func TestPressure(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	if _, err := c.ConsoleFd(0); err != nil {
+	first, err := c.Pressure()
+	if err != nil {
+		if err == ErrPressureUnavailable || err == ErrCgroupNotFound {
+			t.Skip("Skipping test due to kernel support (maybe cgroup1?)")
+			return
+		}
+
 		t.Errorf(err.Error())
+		return
 	}
-}
 
-func TestIPAddress(t *testing.T) {
-	c, err := NewContainer(ContainerName())
+	second, err := c.Pressure()
 	if err != nil {
 		t.Errorf(err.Error())
+		return
 	}
-	defer c.Release()
 
-	if _, err := c.IPAddress("lo"); err != nil {
-		t.Errorf(err.Error())
+	for i, v := range []struct {
+		first  PressureStats
+		second PressureStats
+	}{
+		{first.CPU, second.CPU},
+		{first.Memory, second.Memory},
+		{first.IO, second.IO},
+	} {
+		for _, averages := range []PressureAverages{v.second.Some, v.second.Full} {
+			for _, avg := range []float64{averages.Avg10, averages.Avg60, averages.Avg300} {
+				if avg < 0 || avg > 100 {
+					t.Errorf("unexpected average %f for resource %d", avg, i)
+				}
+			}
+		}
+
+		// All tasks being stalled implies at least one is.
+		if v.second.Full.Total > v.second.Some.Total {
+			t.Errorf("full stall time %s exceeds some stall time %s for resource %d", v.second.Full.Total, v.second.Some.Total, i)
+		}
+
+		if v.second.Some.Total < v.first.Some.Total || v.second.Full.Total < v.first.Full.Total {
+			t.Errorf("stall times went backwards for resource %d: %+v then %+v", i, v.first, v.second)
+		}
 	}
 }
 
-func TestIPv4Address(t *testing.T) {
+func TestSampler(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	if _, err := c.IPv4Address("lo"); err != nil {
-		t.Errorf(err.Error())
-	}
-}
-
-func TestIPv46ddress(t *testing.T) {
-	if !ipv6() {
-		t.Skip("skipping test since lxc bridge does not have ipv6 address")
-	}
+	sampler := NewSampler(SamplerOptions{Interval: 100 * time.Millisecond, History: 2})
 
-	c, err := NewContainer(ContainerName())
+	ctx, cancel := context.WithCancel(context.Background())
+	samples, err := sampler.Sample(ctx, c)
 	if err != nil {
 		t.Errorf(err.Error())
+		cancel()
+		return
 	}
-	defer c.Release()
 
-	if _, err := c.IPv6Address("lo"); err != nil {
-		t.Errorf(err.Error())
+	for i := 0; i < 3; i++ {
+		sample := <-samples
+		if sample.CPU < 0 || sample.CPUs <= 0 {
+			t.Errorf("unexpected sample %+v", sample)
+		}
 	}
-}
+	cancel()
 
-func TestAddDeviceNode(t *testing.T) {
-	if unprivileged() {
-		t.Skip("skipping test in unprivileged mode.")
+	// The channel has to be closed once the context is done.
+	for range samples {
 	}
 
-	if !exists("/dev/network_latency") {
-		t.Skip("skipping the test as it requires/dev/network_latency")
+	if len(sampler.History(c)) != 2 {
+		t.Errorf("unexpected history length %d", len(sampler.History(c)))
 	}
+}
 
+func TestProcesses(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	if err := c.AddDeviceNode("/dev/network_latency"); err != nil {
+	processes, err := c.Processes()
+	if err != nil {
 		t.Errorf(err.Error())
+		return
 	}
-}
 
-func TestRemoveDeviceNode(t *testing.T) {
-	if unprivileged() {
-		t.Skip("skipping test in unprivileged mode.")
+	found := false
+	for _, process := range processes {
+		if process.Pid == c.InitPid() {
+			found = process.NSPid == 1
+		}
 	}
 
-	if !exists("/dev/network_latency") {
-		t.Skip("skipping the test as it requires/dev/network_latency")
+	if !found {
+		t.Errorf("Processes failed to report the init process...")
 	}
+}
 
+func TestMount(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	if err := c.RemoveDeviceNode("/dev/network_latency"); err != nil {
-		t.Errorf(err.Error())
+	dir, err := ioutil.TempDir("", "go-lxc-mount")
+	if err != nil {
+		t.Fatalf(err.Error())
 	}
-}
+	defer os.RemoveAll(dir)
 
-func TestIPv4Addresses(t *testing.T) {
-	c, err := NewContainer(ContainerName())
-	if err != nil {
-		t.Errorf(err.Error())
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("go-lxc"), 0644); err != nil {
+		t.Fatalf(err.Error())
 	}
-	defer c.Release()
 
-	if _, err := c.IPv4Addresses(); err != nil {
+	if err := c.Mount(dir, "/mnt", MountOptions{ReadOnly: true}); err != nil {
 		t.Errorf(err.Error())
+		return
 	}
-}
 
-func TestIPv6Addresses(t *testing.T) {
-	if !ipv6() {
-		t.Skip("skipping test since lxc bridge does not have ipv6 address")
+	if _, err := c.Stat("/mnt/file"); err != nil {
+		t.Errorf("expected the bind mount to be visible: %s", err)
 	}
 
-	c, err := NewContainer(ContainerName())
-	if err != nil {
-		t.Errorf(err.Error())
+	if err := c.PushFile("/mnt/other", strings.NewReader("go-lxc"), FileOptions{}); err == nil {
+		t.Errorf("expected the bind mount to be read-only")
 	}
-	defer c.Release()
 
-	if _, err := c.IPv6Addresses(); err != nil {
+	if err := c.Unmount("/mnt"); err != nil {
 		t.Errorf(err.Error())
 	}
+
+	if _, err := c.Stat("/mnt/file"); !os.IsNotExist(err) {
+		t.Errorf("expected the bind mount to be gone, got %v", err)
+	}
+
+	if err := c.Mount("tmpfs", "relative", MountOptions{FSType: "tmpfs"}); err == nil {
+		t.Errorf("expected a relative target to be refused")
+	}
 }
 
-func TestReboot(t *testing.T) {
+func TestPushPullFile(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	if err := c.Reboot(); err != nil {
-		t.Errorf("Rebooting the container failed...")
+	if err := c.MkdirAll("/tmp/go-lxc", FileOptions{Mode: 0700}); err != nil {
+		t.Errorf(err.Error())
+		return
 	}
-	c.Wait(RUNNING, 30*time.Second)
-}
-
-func TestConcurrentShutdown(t *testing.T) {
-	t.Skip("Skipping concurrent tests for now")
 
-	defer runtime.GOMAXPROCS(runtime.NumCPU())
+	if err := c.PushFile("/tmp/go-lxc/file", strings.NewReader("go-lxc"), FileOptions{Mode: 0600}); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
 
-	var wg sync.WaitGroup
+	r, info, err := c.PullFile("/tmp/go-lxc/file")
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	content, _ := ioutil.ReadAll(r)
+	r.Close()
 
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func(i int) {
-			c, err := NewContainer(strconv.Itoa(i))
-			if err != nil {
-				t.Errorf(err.Error())
-			}
-			defer c.Release()
+	if string(content) != "go-lxc" || info.Mode.Perm() != 0600 || info.UID != 0 {
+		t.Errorf("unexpected file %q %+v", content, info)
+	}
 
-			if err := c.Shutdown(30 * time.Second); err != nil {
-				t.Errorf(err.Error())
-			}
+	entries, err := c.ReadDir("/tmp/go-lxc")
+	if err != nil || len(entries) != 1 || entries[0].Name != "file" {
+		t.Errorf("unexpected entries %+v (%v)", entries, err)
+	}
 
-			c.Wait(STOPPED, 30*time.Second)
-			if c.Running() {
-				t.Errorf("Shutting down the container failed...")
-			}
+	for _, path := range []string{"/tmp/go-lxc/file", "/tmp/go-lxc"} {
+		if err := c.Remove(path); err != nil {
+			t.Errorf(err.Error())
+		}
+	}
 
-			wg.Done()
-		}(i)
+	if _, err := c.Stat("/tmp/go-lxc"); !os.IsNotExist(err) {
+		t.Errorf("expected the directory to be removed, got %v", err)
 	}
-	wg.Wait()
 }
 
-func TestShutdown(t *testing.T) {
-	c, err := NewContainer(ContainerName())
+func TestRunCommandNoWait(t *testing.T) {
+	c, err := NewContainer("TestRunCommandNoWait")
 	if err != nil {
 		t.Errorf(err.Error())
+		t.FailNow()
 	}
 	defer c.Release()
 
-	if err := c.Shutdown(30 * time.Second); err != nil {
+	if err := c.Create(template()); err != nil {
 		t.Errorf(err.Error())
+		t.FailNow()
 	}
+	defer c.Destroy()
 
-	c.Wait(STOPPED, 30*time.Second)
-	if c.Running() {
-		t.Errorf("Shutting down the container failed...")
+	err = c.Start()
+	if err != nil {
+		t.Errorf(err.Error())
+		t.FailNow()
 	}
-}
+	defer c.Stop()
 
-func TestStop(t *testing.T) {
-	c, err := NewContainer(ContainerName())
+	argsThree := []string{"/bin/sh", "-c", "exit 0"}
+	pid, err := c.RunCommandNoWait(argsThree, DefaultAttachOptions)
 	if err != nil {
 		t.Errorf(err.Error())
+		t.FailNow()
 	}
-	defer c.Release()
 
-	if err := c.Start(); err != nil {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
 		t.Errorf(err.Error())
+		t.FailNow()
 	}
 
-	if err := c.Stop(); err != nil {
+	procState, err := proc.Wait()
+	if err != nil {
 		t.Errorf(err.Error())
+		t.FailNow()
+	}
+	if !procState.Success() {
+		t.Errorf("Expected success")
+		t.FailNow()
 	}
 
-	c.Wait(STOPPED, 30*time.Second)
-	if c.Running() {
-		t.Errorf("Stopping the container failed...")
+	argsThree = []string{"/bin/sh", "-c", "exit 1"}
+	pid, err = c.RunCommandNoWait(argsThree, DefaultAttachOptions)
+	if err != nil {
+		t.Errorf(err.Error())
+		t.FailNow()
 	}
-}
 
-func TestDestroySnapshot(t *testing.T) {
-	c, err := NewContainer(ContainerName())
+	proc, err = os.FindProcess(pid)
 	if err != nil {
 		t.Errorf(err.Error())
+		t.FailNow()
 	}
-	defer c.Release()
 
-	snapshot := Snapshot{Name: DefaultSnapshotName}
-	if err := c.DestroySnapshot(snapshot); err != nil {
+	procState, err = proc.Wait()
+	if err != nil {
 		t.Errorf(err.Error())
+		t.FailNow()
+	}
+
+	if procState.Success() {
+		t.Errorf("Expected failure")
+		t.FailNow()
 	}
 }
 
-func TestDestroyAllSnapshots(t *testing.T) {
+func TestRunCommand(t *testing.T) {
 	c, err := NewContainer(ContainerName())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 	defer c.Release()
 
-	if err := c.DestroyAllSnapshots(); err != nil {
-		if err == ErrNotSupported {
-			t.Skip("skipping due to lxc version.")
-		}
+	argsThree := []string{"/bin/sh", "-c", "exit 0"}
+	ok, err := c.RunCommand(argsThree, DefaultAttachOptions)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if !ok {
+		t.Errorf("Expected success")
+	}
+
+	argsThree = []string{"/bin/sh", "-c", "exit 1"}
+	ok, err = c.RunCommand(argsThree, DefaultAttachOptions)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if ok {
+		t.Errorf("Expected failure")
+	}
+}
+
+func TestCommandWithEnv(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	options := DefaultAttachOptions
+	options.Env = []string{"FOO=BAR"}
+	options.ClearEnv = true
+
+	args := []string{"/bin/sh", "-c", "test $FOO = 'BAR'"}
+	ok, err := c.RunCommand(args, options)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if !ok {
+		t.Errorf("Expected success")
+	}
+}
+
+func TestCommandWithEnvToKeep(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	options := DefaultAttachOptions
+	options.ClearEnv = true
+	options.EnvToKeep = []string{"USER"}
+
+	args := []string{"/bin/sh", "-c", fmt.Sprintf("test $USER = '%s'", os.Getenv("USER"))}
+	ok, err := c.RunCommand(args, DefaultAttachOptions)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if !ok {
+		t.Errorf("Expected success")
+	}
+}
+
+func TestCommandWithCwd(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	options := DefaultAttachOptions
+	options.Cwd = "/tmp"
+
+	args := []string{"/bin/sh", "-c", "test `pwd` = /tmp"}
+	ok, err := c.RunCommand(args, options)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if !ok {
+		t.Errorf("Expected success")
+	}
+}
+
+func TestCommandWithUIDGID(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	options := DefaultAttachOptions
+	options.UID = 1000
+	options.GID = 1000
+
+	args := []string{"/bin/sh", "-c", "test `id -u` = 1000 && test `id -g` = 1000"}
+	ok, err := c.RunCommand(args, options)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if !ok {
+		t.Errorf("Expected success")
+	}
+}
+
+func TestCommandWithArch(t *testing.T) {
+	uname := syscall.Utsname{}
+	if err := syscall.Uname(&uname); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	arch := ""
+	for _, c := range uname.Machine {
+		if c == 0 {
+			break
+		}
+		arch += string(byte(c))
+	}
+
+	if arch != "x86_64" && arch != "i686" {
+		t.Skip("skipping architecture test, not on x86")
+	}
+
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	options := DefaultAttachOptions
+	options.Arch = X86
+
+	args := []string{"/bin/sh", "-c", "test `uname -m` = i686"}
+	ok, err := c.RunCommand(args, options)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if !ok {
+		t.Errorf("Expected success")
+	}
+}
+
+func TestConsoleFd(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if _, err := c.ConsoleFd(0); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestIPAddress(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if _, err := c.IPAddress("lo"); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestIPv4Address(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if _, err := c.IPv4Address("lo"); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestIPv46ddress(t *testing.T) {
+	if !ipv6() {
+		t.Skip("skipping test since lxc bridge does not have ipv6 address")
+	}
+
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if _, err := c.IPv6Address("lo"); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestAddDeviceNode(t *testing.T) {
+	if unprivileged() {
+		t.Skip("skipping test in unprivileged mode.")
+	}
+
+	if !exists("/dev/network_latency") {
+		t.Skip("skipping the test as it requires/dev/network_latency")
+	}
+
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if err := c.AddDeviceNode("/dev/network_latency"); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestRemoveDeviceNode(t *testing.T) {
+	if unprivileged() {
+		t.Skip("skipping test in unprivileged mode.")
+	}
+
+	if !exists("/dev/network_latency") {
+		t.Skip("skipping the test as it requires/dev/network_latency")
+	}
+
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if err := c.RemoveDeviceNode("/dev/network_latency"); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestAllowDevice(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	// /dev/fuse
+	rule := DeviceRule{Type: CharDevice, Major: 10, Minor: 229, Access: "rwm"}
+
+	if err := c.AllowDevice(rule, LimitOptions{}); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	if err := c.DenyDevice(rule, LimitOptions{}); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if _, err := c.DeviceRules(); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if err := c.AllowDevice(rule, LimitOptions{Persist: true}); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if err := c.DenyDevice(rule, LimitOptions{Persist: true}); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	for _, value := range c.ConfigItem(cgroupConfigPrefix() + ".devices.allow") {
+		if value == rule.String() {
+			t.Errorf("DenyDevice failed to remove the persisted allow entry...")
+		}
+	}
+
+	// /dev/net/tun
+	other := DeviceRule{Type: CharDevice, Major: 10, Minor: 200, Access: "rwm"}
+
+	for _, v := range []struct {
+		rule  DeviceRule
+		allow bool
+	}{
+		{rule, true},
+		{other, false},
+		{rule, true},
+	} {
+		persist := c.DenyDevice
+		if v.allow {
+			persist = c.AllowDevice
+		}
+
+		if err := persist(v.rule, LimitOptions{Persist: true}); err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+	}
+
+	// The entries are applied in order, the last rule has to come last.
+	var entries []string
+	for _, line := range c.ConfigItem(cgroupConfigPrefix()) {
+		if strings.Contains(line, rule.String()) || strings.Contains(line, other.String()) {
+			entries = append(entries, line)
+		}
+	}
+
+	expected := []string{
+		fmt.Sprintf("%s.devices.deny = %s", cgroupConfigPrefix(), other),
+		fmt.Sprintf("%s.devices.allow = %s", cgroupConfigPrefix(), rule),
+	}
+	if strings.Join(entries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected the persisted entries %q, got %q", expected, entries)
+	}
+}
+
+func TestIPv4Addresses(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if _, err := c.IPv4Addresses(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestIPv6Addresses(t *testing.T) {
+	if !ipv6() {
+		t.Skip("skipping test since lxc bridge does not have ipv6 address")
+	}
+
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if _, err := c.IPv6Addresses(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestReboot(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if err := c.Reboot(); err != nil {
+		t.Errorf("Rebooting the container failed...")
+	}
+	c.Wait(RUNNING, 30*time.Second)
+}
+
+func TestConcurrentShutdown(t *testing.T) {
+	t.Skip("Skipping concurrent tests for now")
+
+	defer runtime.GOMAXPROCS(runtime.NumCPU())
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			c, err := NewContainer(strconv.Itoa(i))
+			if err != nil {
+				t.Errorf(err.Error())
+			}
+			defer c.Release()
+
+			if err := c.Shutdown(30 * time.Second); err != nil {
+				t.Errorf(err.Error())
+			}
+
+			c.Wait(STOPPED, 30*time.Second)
+			if c.Running() {
+				t.Errorf("Shutting down the container failed...")
+			}
+
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestShutdown(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if err := c.Shutdown(30 * time.Second); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	c.Wait(STOPPED, 30*time.Second)
+	if c.Running() {
+		t.Errorf("Shutting down the container failed...")
+	}
+}
+
+func TestStop(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if err := c.Start(); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if err := c.Stop(); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	c.Wait(STOPPED, 30*time.Second)
+	if c.Running() {
+		t.Errorf("Stopping the container failed...")
+	}
+}
+
+func TestDestroySnapshot(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	snapshot := Snapshot{Name: DefaultSnapshotName}
+	if err := c.DestroySnapshot(snapshot); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestDestroyAllSnapshots(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if err := c.DestroyAllSnapshots(); err != nil {
+		if err == ErrNotSupported {
+			t.Skip("skipping due to lxc version.")
+		}
+		t.Errorf(err.Error())
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	c, err := NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	var buf bytes.Buffer
+	if err := c.Export(&buf, ExportOptions{Compression: GzipCompression}); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	lxcpath, err := ioutil.TempDir("", "go-lxc-import")
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	defer os.RemoveAll(lxcpath)
+
+	imported, err := Import(&buf, ContainerRestoreName(), lxcpath, ImportOptions{})
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	defer imported.Release()
+
+	if !imported.Defined() {
+		t.Errorf("Import failed to define the container...")
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	if supported("overlayfs") || supported("overlay") {
+		c, err := NewContainer(ContainerCloneOverlayName())
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		defer c.Release()
+
+		if err := c.Destroy(); err != nil {
+			t.Errorf(err.Error())
+		}
+	}
+
+	c, err := NewContainer(ContainerCloneName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if err := c.Destroy(); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	c, err = NewContainer(ContainerRestoreName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if c.Defined() {
+		if err := c.Destroy(); err != nil {
+			t.Errorf(err.Error())
+		}
+	}
+
+	c, err = NewContainer(ContainerName())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	defer c.Release()
+
+	if err := c.Destroy(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestConcurrentDestroy(t *testing.T) {
+	t.Skip("Skipping concurrent tests for now")
+
+	defer runtime.GOMAXPROCS(runtime.NumCPU())
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			c, err := NewContainer(strconv.Itoa(i))
+			if err != nil {
+				t.Errorf(err.Error())
+			}
+			defer c.Release()
+
+			// sleep for a while to simulate some work
+			time.Sleep(time.Millisecond * time.Duration(rand.Intn(250)))
+
+			if err := c.Destroy(); err != nil {
+				t.Errorf(err.Error())
+			}
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestBackendStore(t *testing.T) {
+	var X struct {
+		store BackendStore
+	}
+
+	if X.store.String() != "" {
+		t.Error("zero value of BackendStore should be invalid")
+	}
+}
+
+func TestState(t *testing.T) {
+	var X struct {
+		state State
+	}
+
+	if X.state.String() != "" {
+		t.Error("zero value of State should be invalid")
+	}
+}
+
+func TestSupportedConfigItems(t *testing.T) {
+	if VersionAtLeast(2, 1, 0) {
+		if !IsSupportedConfigItem("lxc.arch") {
+			t.Errorf("IsSupportedConfigItem failed to detect \"lxc.arch\" as supported config item...")
+		}
+
+		if IsSupportedConfigItem("lxc.nonsense") {
+			t.Errorf("IsSupportedConfigItem failed to detect \"lxc.nonsense\" as unsupported config item...")
+		}
+	}
+}
+
+func TestRuntimeLiblxcVersionAtLeast(t *testing.T) {
+	type args struct {
+		version string
+		major   int
+		minor   int
+		micro   int
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "Check 5.0.0 is at least 2.1.0 returns true",
+			args: args{
+				version: "5.0.0",
+				major:   2,
+				minor:   1,
+				micro:   0,
+			},
+			want: true,
+		},
+		{
+			name: "Check 5.0.0-devel is at least 2.1.0",
+			args: args{
+				version: "5.0.0-devel",
+				major:   2,
+				minor:   1,
+				micro:   0,
+			},
+			want: true,
+		},
+		{
+			name: "Check 5.0.0~git2209-g5a7b9ce67-0ubuntu1 is at least 2.1.0",
+			args: args{
+				version: "5.0.0~git2209-g5a7b9ce67-0ubuntu1",
+				major:   2,
+				minor:   1,
+				micro:   0,
+			},
+			want: true,
+		},
+		{
+			name: "Check 1.0.0 is not at least 2.1.0 returns true",
+			args: args{
+				version: "1.0.0",
+				major:   2,
+				minor:   1,
+				micro:   0,
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RuntimeLiblxcVersionAtLeast(tt.args.version, tt.args.major, tt.args.minor, tt.args.micro); got != tt.want {
+				t.Errorf("RuntimeLiblxcVersionAtLeast() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIOStat(t *testing.T) {
+	devices, err := parseIOStat([]string{
+		"8:16 rbytes=1024 wbytes=2048 rios=3 wios=4 dbytes=0 dios=0",
+		"8:0 rbytes=512 wbytes=0 rios=1 wios=0 dbytes=0 dios=0",
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(devices) != 2 {
+		t.Fatalf("parseIOStat returned %d devices, want 2", len(devices))
+	}
+
+	if devices[0].Minor != 0 || devices[0].ReadBytes != 512 || devices[0].ReadOps != 1 {
+		t.Errorf("parseIOStat failed to parse 8:0: %+v", devices[0])
+	}
+
+	if devices[1].Minor != 16 || devices[1].WriteBytes != 2048 || devices[1].WriteOps != 4 {
+		t.Errorf("parseIOStat failed to parse 8:16: %+v", devices[1])
+	}
+}
+
+func TestParseBlkioThrottle(t *testing.T) {
+	devices, err := parseBlkioThrottle([]string{
+		"8:0 Read 4096",
+		"8:0 Write 8192",
+		"8:0 Sync 12288",
+		"8:0 Async 0",
+		"8:0 Total 12288",
+		"Total 12288",
+	}, []string{
+		"8:0 Read 1",
+		"8:0 Write 2",
+		"8:0 Sync 3",
+		"8:0 Async 0",
+		"8:0 Total 3",
+		"Total 3",
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(devices) != 1 {
+		t.Fatalf("parseBlkioThrottle returned %d devices, want 1", len(devices))
+	}
+
+	want := BlockIODevice{
+		Major:      8,
+		Minor:      0,
+		ReadBytes:  4096,
+		WriteBytes: 8192,
+		ReadOps:    1,
+		WriteOps:   2,
+		SyncBytes:  12288,
+		SyncOps:    3,
+	}
+	if devices[0] != want {
+		t.Errorf("parseBlkioThrottle() = %+v, want %+v", devices[0], want)
+	}
+}
+
+func TestParseCgroupLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"max", -1},
+		{"9223372036854771712", -1},
+		{"1073741824", 1073741824},
+	}
+	for _, tt := range tests {
+		got, err := parseCgroupLimit(tt.value)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		if got != tt.want {
+			t.Errorf("parseCgroupLimit(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParsePressure(t *testing.T) {
+	stats, err := parsePressure([]string{
+		"some avg10=1.50 avg60=0.25 avg300=0.00 total=123456",
+		"full avg10=0.75 avg60=0.00 avg300=0.00 total=1000",
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if stats.Some.Avg10 != 1.5 || stats.Some.Avg60 != 0.25 || stats.Some.Total != 123456*time.Microsecond {
+		t.Errorf("parsePressure failed to parse some: %+v", stats.Some)
+	}
+
+	if stats.Full.Avg10 != 0.75 || stats.Full.Total != time.Millisecond {
+		t.Errorf("parsePressure failed to parse full: %+v", stats.Full)
+	}
+}
+
+func TestFindCgroupPath(t *testing.T) {
+	lines := []string{
+		"12:pids:/lxc.payload.c1",
+		"4:cpu,cpuacct:/lxc.payload.c1/init.scope",
+		"1:name=systemd:/lxc/c1/init.scope",
+		"0::/lxc.payload.c1/init.scope",
+	}
+
+	tests := []struct {
+		controller string
+		hierarchy  string
+		path       string
+	}{
+		{"", "", "/lxc.payload.c1"},
+		{"cpuacct", "cpu,cpuacct", "/lxc.payload.c1"},
+		{"pids", "pids", "/lxc.payload.c1"},
+		{"name=systemd", "name=systemd", "/lxc/c1"},
+	}
+	for _, tt := range tests {
+		hierarchy, path, err := findCgroupPath(lines, tt.controller, "c1")
+		if err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+
+		if hierarchy != tt.hierarchy || path != tt.path {
+			t.Errorf("findCgroupPath(%q) = %q, %q, want %q, %q", tt.controller, hierarchy, path, tt.hierarchy, tt.path)
+		}
+	}
+
+	if _, _, err := findCgroupPath(lines, "memory", "c1"); err != ErrCgroupNotFound {
+		t.Errorf("findCgroupPath failed to report a missing controller")
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("0-2,5,4-5")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if fmt.Sprint(cpus) != "[0 1 2 4 5]" {
+		t.Errorf("parseCPUList() = %v, want [0 1 2 4 5]", cpus)
+	}
+
+	for _, list := range []string{"a", "3-1", "1,-2"} {
+		if _, err := parseCPUList(list); err == nil {
+			t.Errorf("parseCPUList(%q) should have failed", list)
+		}
+	}
+}
+
+func TestCPULimitsSettings(t *testing.T) {
+	limits := CPULimits{Weight: 100, Quota: 50 * time.Millisecond, Period: 100 * time.Millisecond}
+
+	got := fmt.Sprint(limits.settings(true, ""))
+	if want := "[{cpu.weight 100} {cpu.max 50000 100000}]"; got != want {
+		t.Errorf("settings(v2) = %s, want %s", got, want)
+	}
+
+	got = fmt.Sprint(limits.settings(false, ""))
+	if want := "[{cpu.shares 2597} {cpu.cfs_period_us 100000} {cpu.cfs_quota_us 50000}]"; got != want {
+		t.Errorf("settings(v1) = %s, want %s", got, want)
+	}
+
+	got = fmt.Sprint(CPULimits{Quota: -1}.settings(true, "50000 200000"))
+	if want := "[{cpu.max max 200000}]"; got != want {
+		t.Errorf("settings(v2) = %s, want %s", got, want)
+	}
+
+	if cpuSharesToWeight(1024) != 39 || cpuWeightToShares(39) != 998 {
+		t.Errorf("cpu.shares and cpu.weight conversion failed")
+	}
+}
+
+func TestIOLimitSettings(t *testing.T) {
+	limits := []IOLimit{{Device: "8:0", ReadBPS: 1 * MB, WriteIOPS: 100}}
+
+	got := fmt.Sprint(ioLimitSettings(limits, true))
+	if want := "[{io.max 8:0 rbps=1048576 wbps=max riops=max wiops=100}]"; got != want {
+		t.Errorf("ioLimitSettings(v2) = %s, want %s", got, want)
+	}
+
+	got = fmt.Sprint(ioLimitSettings(limits, false))
+	want := "[{blkio.throttle.read_bps_device 8:0 1048576} {blkio.throttle.write_bps_device 8:0 0} " +
+		"{blkio.throttle.read_iops_device 8:0 0} {blkio.throttle.write_iops_device 8:0 100}]"
+	if got != want {
+		t.Errorf("ioLimitSettings(v1) = %s, want %s", got, want)
+	}
+
+	parsed, err := parseIOMax([]string{"8:0 rbps=1048576 wbps=max riops=max wiops=100"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(parsed) != 1 || parsed[0] != limits[0] {
+		t.Errorf("parseIOMax() = %+v, want %+v", parsed, limits)
+	}
+
+	parsed, err = parseBlkioThrottleLimits([][]string{{"8:0 1048576"}, {""}, {""}, {"8:0 100"}})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(parsed) != 1 || parsed[0] != limits[0] {
+		t.Errorf("parseBlkioThrottleLimits() = %+v, want %+v", parsed, limits)
+	}
+
+	parsed, err = parseIOMax([]string{"8:16 wiops=1", "8:2 wiops=1", "259:0 wiops=1"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(parsed) != 3 || parsed[0].Device != "8:2" || parsed[1].Device != "8:16" || parsed[2].Device != "259:0" {
+		t.Errorf("parseIOMax() = %+v, want devices sorted by number", parsed)
+	}
+}
+
+func TestHugetlbName(t *testing.T) {
+	tests := []struct {
+		dir  string
+		name string
+	}{
+		{"hugepages-64kB", "64KB"},
+		{"hugepages-2048kB", "2MB"},
+		{"hugepages-1048576kB", "1GB"},
+	}
+	for _, tt := range tests {
+		size, err := parseHugepageDir(tt.dir)
+		if err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+
+		if got := hugetlbName(size); got != tt.name {
+			t.Errorf("hugetlbName(%s) = %s, want %s", size, got, tt.name)
+		}
+	}
+}
+
+func TestParseMemoryEvents(t *testing.T) {
+	counters, err := parseMemoryEvents([]string{
+		"low 0",
+		"high 12",
+		"max 3",
+		"oom 2",
+		"oom_kill 1",
+		"oom_group_kill 0",
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	want := MemoryEventCounters{High: 12, Max: 3, OOM: 2, OOMKill: 1}
+	if counters != want {
+		t.Errorf("parseMemoryEvents() = %+v, want %+v", counters, want)
+	}
+
+	delta := counters.sub(MemoryEventCounters{High: 10, Max: 3, OOM: 1})
+	if delta != (MemoryEventCounters{High: 2, OOM: 1, OOMKill: 1}) {
+		t.Errorf("MemoryEventCounters.sub() = %+v", delta)
+	}
+}
+
+func TestNewSample(t *testing.T) {
+	now := time.Now()
+
+	previous := &Stats{
+		Time:    now,
+		Memory:  MemoryStats{Usage: 100 * MB},
+		CPU:     CPUAccounting{Usage: time.Second},
+		BlockIO: []BlockIODevice{{Major: 8, Minor: 0, ReadBytes: 0, WriteBytes: 1000, ReadOps: 10}},
+		Network: map[string]*NetworkInterfaceStats{
+			"eth0": {Rx: NetworkCounters{Bytes: 1000}, Tx: NetworkCounters{Bytes: 5000}},
+			"lo":   {Rx: NetworkCounters{Bytes: 0}},
+		},
+	}
+
+	current := &Stats{
+		Time:    now.Add(2 * time.Second),
+		Memory:  MemoryStats{Usage: 80 * MB},
+		CPU:     CPUAccounting{Usage: 3 * time.Second},
+		BlockIO: []BlockIODevice{{Major: 8, Minor: 0, ReadBytes: 4000, WriteBytes: 1000, ReadOps: 30}},
+		Network: map[string]*NetworkInterfaceStats{
+			"eth0": {Rx: NetworkCounters{Bytes: 3000}, Tx: NetworkCounters{Bytes: 0}},
+			"lo":   {Rx: NetworkCounters{Bytes: 8000}},
+		},
+	}
+
+	sample := newSample(previous, current, 4)
+	if sample.Interval != 2*time.Second {
+		t.Errorf("unexpected interval %s", sample.Interval)
+	}
+	if sample.CPU != 25 {
+		t.Errorf("unexpected CPU usage %f", sample.CPU)
+	}
+	if sample.MemoryDelta != -20*MB {
+		t.Errorf("unexpected memory delta %s", sample.MemoryDelta)
+	}
+	if sample.NetworkRx != 1000 || sample.NetworkTx != 0 {
+		t.Errorf("unexpected network rates %s %s", sample.NetworkRx, sample.NetworkTx)
+	}
+	if sample.BlockIORead != 2000 || sample.BlockIOWrite != 0 || sample.ReadIOPS != 10 || sample.WriteIOPS != 0 {
+		t.Errorf("unexpected block I/O rates %+v", sample)
+	}
+}
+
+func TestSampleRing(t *testing.T) {
+	ring := newSampleRing(3)
+	if len(ring.samples()) != 0 {
+		t.Errorf("expected an empty history")
+	}
+
+	for i := 1; i <= 5; i++ {
+		ring.add(Sample{CPUs: i})
+	}
+
+	samples := ring.samples()
+	if len(samples) != 3 || samples[0].CPUs != 3 || samples[2].CPUs != 5 {
+		t.Errorf("unexpected history %+v", samples)
+	}
+
+	ring = newSampleRing(0)
+	ring.add(Sample{})
+	if len(ring.samples()) != 0 {
+		t.Errorf("expected no history to be kept")
+	}
+}
+
+func TestParseNetDev(t *testing.T) {
+	lines := []string{
+		"Inter-|   Receive                                                |  Transmit",
+		" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed",
+		"    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0",
+		"  eth0: 1939466     217    1    2    0     0          0         3    35747     331    4    5    0     0       0          0",
+		"",
+	}
+
+	interfaces, err := parseNetDev(lines)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(interfaces) != 2 {
+		t.Fatalf("unexpected interfaces %+v", interfaces)
+	}
+
+	eth0 := interfaces["eth0"]
+	if eth0 == nil || eth0.Name != "eth0" {
+		t.Fatalf("missing eth0 in %+v", interfaces)
+	}
+
+	expected := NetworkInterfaceStats{
+		Name:      "eth0",
+		Rx:        NetworkCounters{Bytes: 1939466, Packets: 217, Errors: 1, Dropped: 2},
+		Tx:        NetworkCounters{Bytes: 35747, Packets: 331, Errors: 4, Dropped: 5},
+		Multicast: 3,
+	}
+	if *eth0 != expected {
+		t.Errorf("expected %+v, got %+v", expected, *eth0)
+	}
+
+	if _, err := parseNetDev([]string{"eth0: 1 2 3"}); err == nil {
+		t.Errorf("expected an error for a truncated line")
+	}
+	igmp := []string{
+		"Idx\tDevice    : Count Querier\tGroup    Users Timer\tReporter",
+		"1\tlo        :     1      V3",
+		"\t\t\t\t010000E0     1 0:00000000\t\t0",
+		"12\teth1      :     1      V3",
+		"\t\t\t\t010000E0     1 0:00000000\t\t0",
+		"",
+	}
+
+	if names := parseIGMPInterfaces(igmp); len(names) != 2 || names[1] != "lo" || names[12] != "eth1" {
+		t.Errorf("unexpected interfaces %v", names)
+	}
+}
+
+func TestParseProcStatus(t *testing.T) {
+	lines := []string{
+		"Name:\tsleep",
+		"State:\tS (sleeping)",
+		"PPid:\t1234",
+		"Uid:\t100033\t100033\t100033\t100033",
+		"NSpid:\t5678\t42",
+		"VmRSS:\t     512 kB",
+		"",
+	}
+
+	process, err := parseProcStatus(lines)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if process.Command != "sleep" || process.State != "S" || process.PPid != 1234 ||
+		process.HostUID != 100033 || process.NSPid != 42 || process.RSS != 512*KB {
+		t.Errorf("unexpected process %+v", *process)
+	}
+
+	cpuTime, err := parseProcStatCPUTime("42 (a (weird) name) S 1 42 42 0 -1 4194560 100 0 0 0 150 50 0 0 20 0 1 0 100")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if cpuTime != 2*time.Second {
+		t.Errorf("unexpected CPU time %s", cpuTime)
+	}
+}
+
+func TestIDMap(t *testing.T) {
+	m := parseIDMap([]string{"u 0 100000 65536", "g 0 100000 65536", "u 1000 1000 1", "bogus"})
+
+	for _, v := range []struct {
+		host      int
+		container int
+	}{
+		{100000, 0},
+		{100033, 33},
+		{1000, 1000},
+		{0, -1},
+	} {
+		if uid := m.containerUID(v.host); uid != v.container {
+			t.Errorf("expected host UID %d to map to %d, got %d", v.host, v.container, uid)
+		}
+	}
+
+	if uid := parseIDMap(nil).containerUID(33); uid != 33 {
+		t.Errorf("expected privileged containers to keep UIDs, got %d", uid)
+	}
+}
+
+func TestParsePasswd(t *testing.T) {
+	users := parsePasswd([]string{
+		"root:x:0:0:root:/root:/bin/bash",
+		"www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin",
+		"toor:x:0:0::/root:/bin/sh",
+		"bogus:x:",
+		"",
+	})
+
+	if len(users) != 2 || users[0] != "root" || users[33] != "www-data" {
+		t.Errorf("unexpected users %v", users)
+	}
+}
+
+func TestDeviceRule(t *testing.T) {
+	for _, v := range []struct {
+		rule     string
+		expected DeviceRule
+	}{
+		{"c 10:229 rwm", DeviceRule{Type: CharDevice, Major: 10, Minor: 229, Access: "rwm", Allow: true}},
+		{"b 8:* r", DeviceRule{Type: BlockDevice, Major: 8, Minor: AnyDevice, Access: "r", Allow: true}},
+		{"c *:* m", DeviceRule{Type: CharDevice, Major: AnyDevice, Minor: AnyDevice, Access: "m", Allow: true}},
+		{"a *:* rwm", DeviceRule{Type: AllDevices, Major: AnyDevice, Minor: AnyDevice, Access: "rwm", Allow: true}},
+		{"a", DeviceRule{Type: AllDevices, Major: AnyDevice, Minor: AnyDevice, Access: "rwm", Allow: true}},
+	} {
+		rule, err := parseDeviceRule(v.rule, true)
+		if err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+
+		if rule != v.expected {
+			t.Errorf("expected %q to parse as %+v, got %+v", v.rule, v.expected, rule)
+		}
+	}
+
+	for _, v := range []string{"", "x 1:1 r", "c 1 r", "c 1:1", "c 1:1 rx", "c -2:1 r"} {
+		if _, err := parseDeviceRule(v, true); err == nil {
+			t.Errorf("expected %q to be rejected", v)
+		}
+	}
+
+	rule := DeviceRule{Type: CharDevice, Major: 136, Minor: AnyDevice, Access: "rw"}
+	if rule.String() != "c 136:* rw" {
+		t.Errorf("unexpected rule %q", rule.String())
+	}
+
+	if (DeviceRule{Type: AllDevices}).String() != "a" {
+		t.Errorf("unexpected rule %q", DeviceRule{Type: AllDevices}.String())
+	}
+}
+
+func TestParseSnapshotTimestamp(t *testing.T) {
+	ts, err := parseSnapshotTimestamp("2015:10:21 16:29:00\n")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := time.Date(2015, time.October, 21, 16, 29, 0, 0, time.Local)
+	if !ts.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, ts)
+	}
+
+	if _, err := parseSnapshotTimestamp("yesterday"); err == nil {
+		t.Errorf("expected an error for a malformed timestamp")
+	}
+}
+
+func TestSelectSnapshotsToPrune(t *testing.T) {
+	now := time.Date(2020, time.March, 12, 12, 0, 0, 0, time.UTC)
+
+	// Two snapshots a day over the last 20 days, snap0 being the oldest.
+	var snapshots []Snapshot
+	for i := 0; i < 40; i++ {
+		snapshots = append(snapshots, Snapshot{
+			Name: fmt.Sprintf("snap%d", i),
+			Time: now.Add(-time.Duration(39-i) * 12 * time.Hour),
+		})
+	}
+	snapshots = append(snapshots, Snapshot{Name: "undated"})
+
+	names := func(snapshots []Snapshot) map[string]bool {
+		result := make(map[string]bool)
+		for _, snapshot := range snapshots {
+			result[snapshot.Name] = true
+		}
+		return result
+	}
+
+	prune := names(selectSnapshotsToPrune(snapshots, RetentionPolicy{}, now))
+	if len(prune) != 0 {
+		t.Errorf("expected an empty policy to keep everything, got %v", prune)
+	}
+
+	prune = names(selectSnapshotsToPrune(snapshots, RetentionPolicy{KeepLast: 3}, now))
+	if len(prune) != 37 || prune["snap39"] || prune["snap37"] || !prune["snap36"] || prune["undated"] {
+		t.Errorf("unexpected snapshots to prune %v", prune)
+	}
+
+	// The most recent snapshot of each of the last 2 days and 2 weeks.
+	prune = names(selectSnapshotsToPrune(snapshots, RetentionPolicy{KeepDaily: 2, KeepWeekly: 2}, now))
+	for _, name := range []string{"snap39", "snap37"} {
+		if prune[name] {
+			t.Errorf("expected daily snapshot %s to be kept", name)
+		}
+	}
+	if len(prune) != 37 {
+		t.Errorf("unexpected snapshots to prune %v", prune)
+	}
+
+	prune = names(selectSnapshotsToPrune(snapshots, RetentionPolicy{MaxAge: 24 * time.Hour}, now))
+	if len(prune) != 37 || prune["snap37"] || !prune["snap36"] {
+		t.Errorf("unexpected snapshots to prune %v", prune)
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-lxc-tar")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "etc"), 0755); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "etc", "hostname"), []byte("go-lxc\n"), 0640); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := os.Link(filepath.Join(src, "etc", "hostname"), filepath.Join(src, "hostname")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := os.Symlink("etc/hostname", filepath.Join(src, "link")); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, compression := range []Compression{NoCompression, GzipCompression, ZstdCompression} {
+		var buf bytes.Buffer
+
+		var out io.WriteCloser = nopWriteCloser{&buf}
+		switch compression {
+		case GzipCompression:
+			out = gzip.NewWriter(&buf)
+		case ZstdCompression:
+			if out, err = zstd.NewWriter(&buf); err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+
+		tw := tar.NewWriter(out)
+		if err := newTarArchiver(tw).add(src, "rootfs", nil); err != nil {
+			t.Fatalf(err.Error())
+		}
+		tw.Close()
+		out.Close()
+
+		in, err := decompress(&buf)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		dst := filepath.Join(dir, compression.String())
+		if err := os.Mkdir(dst, 0755); err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if err := extractTar(tar.NewReader(in), dst, nil, false); err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(dst, "rootfs", "link"))
+		if err != nil || string(content) != "go-lxc\n" {
+			t.Errorf("%s: unexpected content %q (%v)", compression, content, err)
+		}
+
+		info, err := os.Stat(filepath.Join(dst, "rootfs", "etc", "hostname"))
+		if err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("%s: unexpected mode %v (%v)", compression, info, err)
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Nlink != 2 {
+			t.Errorf("%s: expected the hard link to be preserved", compression)
+		}
+	}
+}
+
+func TestExtractTarSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-lxc-tar")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	outside := filepath.Join(dir, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "rootfs/x", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "rootfs/x/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 6},
+		{Name: "rootfs/y", Typeflag: tar.TypeSymlink, Linkname: "../../outside/shadow"},
+		{Name: "rootfs/y", Typeflag: tar.TypeReg, Mode: 0644, Size: 6},
+	} {
+		hdr.Uid = os.Getuid()
+		hdr.Gid = os.Getgid()
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("go-lxc"))
+		}
+	}
+	tw.Close()
+
+	if err := extractTar(tar.NewReader(&buf), root, nil, false); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	entries, err := ioutil.ReadDir(outside)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("expected nothing to be written outside of the root, got %v (%v)", entries, err)
+	}
+
+	// Absolute symlinks are resolved inside the root.
+	if _, err := os.Stat(filepath.Join(root, outside, "passwd")); err != nil {
 		t.Errorf(err.Error())
 	}
+
+	if info, err := os.Lstat(filepath.Join(root, "rootfs", "y")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("expected the symlink to be replaced by the file (%v)", err)
+	}
+}
+
+func TestSecurePath(t *testing.T) {
+	for _, name := range []string{"rootfs/etc", "/rootfs/etc", "rootfs/../rootfs/etc"} {
+		path, err := securePath("/var/lib/lxc/c1", name)
+		if err != nil || path != "/var/lib/lxc/c1/rootfs/etc" {
+			t.Errorf("unexpected path %q for %q (%v)", path, name, err)
+		}
+	}
+
+	// Names are confined to the root.
+	path, _ := securePath("/var/lib/lxc/c1", "../../../../etc/shadow")
+	if path != "/var/lib/lxc/c1/etc/shadow" {
+		t.Errorf("unexpected path %q", path)
+	}
+}
+
+func TestRewriteImportedConfig(t *testing.T) {
+	index := exportIndex{Name: "c1", Path: "/var/lib/lxc", RootfsPath: "/srv/c1"}
+
+	config := strings.Join([]string{
+		"# Template used to create this container",
+		"lxc.rootfs.path = dir:/srv/c1",
+		"lxc.uts.name = c1",
+		"lxc.mount.fstab = /var/lib/lxc/c1/fstab",
+		"lxc.log.file = /var/lib/lxc/c10/log",
+		"lxc.hook.start-host = /srv/c1/hook",
+	}, "\n")
+
+	expected := strings.Join([]string{
+		"# Template used to create this container",
+		"lxc.rootfs.path = lvm:/dev/lxc/c2",
+		"lxc.uts.name = c2",
+		"lxc.mount.fstab = /data/c2/fstab",
+		"lxc.log.file = /var/lib/lxc/c10/log",
+		"lxc.hook.start-host = /data/c2/rootfs/hook",
+	}, "\n")
+
+	if rewritten := rewriteImportedConfig(config, index, "/data/c2", "c2", "lvm:/dev/lxc/c2"); rewritten != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, rewritten)
+	}
+
+	snapshot := "lxc.rootfs.path = overlay:/var/lib/lxc/c1/rootfs:/var/lib/lxc/c1/snaps/snap0/delta0"
+	expected = "lxc.rootfs.path = overlay:/data/c2/rootfs:/data/c2/snaps/snap0/delta0"
+	if rewritten := rewriteImportedConfig(snapshot, exportIndex{Name: "c1", Path: "/var/lib/lxc"}, "/data/c2", "c2", ""); rewritten != expected {
+		t.Errorf("expected %q, got %q", expected, rewritten)
+	}
+}
+
+func TestImportReader(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "config", Typeflag: tar.TypeReg},
+		{Name: "snaps/", Typeflag: tar.TypeDir},
+		{Name: "rootfs", Typeflag: tar.TypeDir},
+		{Name: "rootfs/bin/sh", Typeflag: tar.TypeReg},
+		{Name: "rootfs/bin/bash", Typeflag: tar.TypeLink, Linkname: "rootfs/bin/sh"},
+		{Name: "fstab", Typeflag: tar.TypeReg},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ir := &importReader{tr: tar.NewReader(&buf)}
+
+	var names []string
+	next := func() error {
+		hdr, err := ir.Next()
+		if err == nil {
+			names = append(names, hdr.Name+">"+hdr.Linkname)
+		}
+		return err
+	}
+
+	for err := next(); err != io.EOF; err = next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ir.rootfs = true
+	var err error
+	for err = next(); err == nil; err = next() {
+	}
+	if err == io.EOF {
+		t.Errorf("expected an error for the entry after the root filesystem")
+	}
+
+	expected := []string{"config>", "snaps/>", ".>", "bin/sh>", "bin/bash>bin/sh"}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestMountRootfsArgs(t *testing.T) {
+	for _, v := range []struct {
+		backend  BackendStore
+		source   string
+		lower    string
+		readOnly bool
+		args     string
+	}{
+		{LVM, "/dev/lxc/c1", "", false, "/dev/lxc/c1 /mnt"},
+		{LVM, "/dev/lxc/c1", "", true, "-o ro /dev/lxc/c1 /mnt"},
+		{Loopback, "/var/lib/lxc/c1/rootdev", "", true, "-o ro,loop /var/lib/lxc/c1/rootdev /mnt"},
+		{ZFS, "lxc/c1", "", false, "-t zfs -o zfsutil lxc/c1 /mnt"},
+		{Overlayfs, "/var/lib/lxc/c2/overlay/delta", "/var/lib/lxc/c1/rootfs", true, "-t overlay -o ro,lowerdir=/var/lib/lxc/c2/overlay/delta:/var/lib/lxc/c1/rootfs overlay /mnt"},
+		{Aufs, "/var/lib/lxc/c2/delta0", "/var/lib/lxc/c1/rootfs", true, "-t aufs -o ro,br=/var/lib/lxc/c2/delta0=ro:/var/lib/lxc/c1/rootfs=ro none /mnt"},
+	} {
+		args, err := mountRootfsArgs(v.backend, v.source, v.lower, "/mnt", v.readOnly)
+		if err != nil || strings.Join(args, " ") != v.args {
+			t.Errorf("expected %q for %s, got %q (%v)", v.args, v.backend, strings.Join(args, " "), err)
+		}
+	}
+
+	if _, err := mountRootfsArgs(Overlayfs, "/upper", "/lower", "/mnt", false); err == nil {
+		t.Errorf("expected overlay to be refused read-write")
+	}
+}
+
+func TestParseRootfsPath(t *testing.T) {
+	for _, v := range []struct {
+		rootfs  string
+		backend BackendStore
+		source  string
+		lower   string
+	}{
+		{"/var/lib/lxc/c1/rootfs", Directory, "/var/lib/lxc/c1/rootfs", ""},
+		{"dir:/var/lib/lxc/c1/rootfs", Directory, "/var/lib/lxc/c1/rootfs", ""},
+		{"btrfs:/var/lib/lxc/c1/rootfs", Btrfs, "/var/lib/lxc/c1/rootfs", ""},
+		{"zfs:lxc/c1", ZFS, "lxc/c1", ""},
+		{"lvm:/dev/lxc/c1", LVM, "/dev/lxc/c1", ""},
+		{"loop:/var/lib/lxc/c1/rootdev", Loopback, "/var/lib/lxc/c1/rootdev", ""},
+		{"overlay:/var/lib/lxc/c0/rootfs:/var/lib/lxc/c1/delta0", Overlayfs, "/var/lib/lxc/c1/delta0", "/var/lib/lxc/c0/rootfs"},
+		{"overlayfs:/var/lib/lxc/c0/rootfs:/var/lib/lxc/c1/delta0", Overlayfs, "/var/lib/lxc/c1/delta0", "/var/lib/lxc/c0/rootfs"},
+	} {
+		backend, source, lower, err := parseRootfsPath(v.rootfs)
+		if err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+
+		if backend != v.backend || source != v.source || lower != v.lower {
+			t.Errorf("unexpected %s %q %q for %q", backend, source, lower, v.rootfs)
+		}
+	}
+
+	for _, v := range []string{"", "rbd:pool/c1", "lvm:", "overlay:/var/lib/lxc/c1"} {
+		if _, _, _, err := parseRootfsPath(v); err == nil {
+			t.Errorf("expected %q to be rejected", v)
+		}
+	}
+}
+
+func TestProbeSuperblock(t *testing.T) {
+	buf := make([]byte, btrfsMagicOffset+8)
+	if probeSuperblock(buf) != "" {
+		t.Errorf("expected an empty device to be unknown")
+	}
+
+	ext4 := append([]byte(nil), buf...)
+	ext4[ext4MagicOffset] = 0x53
+	ext4[ext4MagicOffset+1] = 0xef
+	if fs := probeSuperblock(ext4); fs != "ext4" {
+		t.Errorf("expected ext4, got %q", fs)
+	}
+
+	xfs := append([]byte(nil), buf...)
+	copy(xfs, "XFSB")
+	if fs := probeSuperblock(xfs); fs != "xfs" {
+		t.Errorf("expected xfs, got %q", fs)
+	}
+
+	btrfs := append([]byte(nil), buf...)
+	copy(btrfs[btrfsMagicOffset:], "_BHRfS_M")
+	if fs := probeSuperblock(btrfs); fs != "btrfs" {
+		t.Errorf("expected btrfs, got %q", fs)
+	}
+}
+
+func TestGrowFilesystemCommands(t *testing.T) {
+	commands, err := growFilesystemCommands("ext4", "/dev/lxc/c1", "/mnt", false)
+	if err != nil || len(commands) != 2 || commands[1][0] != "resize2fs" {
+		t.Errorf("unexpected commands %v (%v)", commands, err)
+	}
+
+	commands, err = growFilesystemCommands("xfs", "/var/lib/lxc/c1/rootdev", "/mnt", true)
+	if err != nil || len(commands) != 3 || strings.Join(commands[0], " ") != "mount -o loop /var/lib/lxc/c1/rootdev /mnt" {
+		t.Errorf("unexpected commands %v (%v)", commands, err)
+	}
+
+	commands, err = growFilesystemCommands("btrfs", "/dev/lxc/c1", "/mnt", false)
+	if err != nil || len(commands) != 3 || commands[1][0] != "btrfs" {
+		t.Errorf("unexpected commands %v (%v)", commands, err)
+	}
+
+	if _, err := growFilesystemCommands("", "/dev/lxc/c1", "/mnt", false); err != ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+	for _, v := range []struct {
+		name      string
+		status    string
+		succeeded bool
+	}{
+		{"e2fsck", "0", true},
+		{"e2fsck", "1", true},
+		{"e2fsck", "4", false},
+		{"resize2fs", "1", false},
+	} {
+		if succeeded := commandSucceeded(v.name, runCommand("sh", "-c", "exit "+v.status)); succeeded != v.succeeded {
+			t.Errorf("expected exit status %s of %s to succeed: %t", v.status, v.name, v.succeeded)
+		}
+	}
+}
+
+func TestSecureJoin(t *testing.T) {
+	root, err := ioutil.TempDir("", "go-lxc-root")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for name, target := range map[string]string{
+		"absolute": "/etc",
+		"relative": "../../../etc",
+		"loop":     "loop",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	for _, v := range []struct {
+		path     string
+		expected string
+	}{
+		{"/etc/hostname", "/etc/hostname"},
+		{"../../etc/hostname", "/etc/hostname"},
+		{"absolute/hostname", "/etc/hostname"},
+		{"relative/hostname", "/etc/hostname"},
+		{"missing/../etc", "/etc"},
+	} {
+		resolved, err := secureJoin(root, v.path)
+		if err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+
+		if resolved != filepath.Join(root, v.expected) {
+			t.Errorf("expected %q to resolve to %q, got %q", v.path, v.expected, resolved)
+		}
+	}
+
+	if _, err := secureJoin(root, "loop/file"); err != syscall.ELOOP {
+		t.Errorf("expected ELOOP, got %v", err)
+	}
+}
+
+func TestContainerRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-lxc-root")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	root := &containerRoot{fd: fd, path: dir}
+	defer root.close()
+
+	if err := os.Symlink("/", filepath.Join(dir, "escape")); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	if err := root.mkdir("/escape/go-lxc", 0750, uid, gid); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// The directory is created in the root, not on the host.
+	info, err := os.Stat(filepath.Join(dir, "go-lxc"))
+	if err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("unexpected directory %v (%v)", info, err)
+	}
+
+	dirfd, base, err := root.openParent("/escape")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer syscall.Close(dirfd)
+
+	link, err := root.fileInfo(dirfd, base)
+	if err != nil || link.LinkTarget != "/" || link.Mode&os.ModeSymlink == 0 {
+		t.Errorf("unexpected symlink %+v (%v)", link, err)
+	}
+}
+
+func TestContainerRootSpecialFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-lxc-root")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	root := &containerRoot{fd: fd, path: dir}
+	defer root.close()
+
+	paths := []string{"/fifo", "/link"}
+	if err := syscall.Mkfifo(filepath.Join(dir, "fifo"), 0666); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := os.Symlink("/fifo", filepath.Join(dir, "link")); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// /dev/null, creating device nodes requires CAP_MKNOD.
+	if err := syscall.Mknod(filepath.Join(dir, "null"), syscall.S_IFCHR|0666, 1<<8|3); err == nil {
+		paths = append(paths, "/null")
+	} else {
+		t.Logf("not checking device nodes: %s", err)
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	for _, path := range paths {
+		done := make(chan error, 2)
+		go func() {
+			f, _, err := root.pullFile(path)
+			if err == nil {
+				f.Close()
+			}
+			done <- err
+		}()
+		go func() {
+			done <- root.pushFile(path, strings.NewReader("go-lxc"), uid, gid, 0644)
+		}()
+
+		for i := 0; i < 2; i++ {
+			select {
+			case err := <-done:
+				if err == nil || !strings.HasPrefix(err.Error(), ErrNotRegularFile.Error()) {
+					t.Errorf("expected %s to be refused, got %v", path, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("opening %s blocked", path)
+			}
+		}
+	}
+
+	if err := root.pushFile("/file", strings.NewReader("go-lxc"), uid, gid, 0600); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	f, info, err := root.pullFile("/file")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer f.Close()
+
+	content, _ := ioutil.ReadAll(f)
+	if string(content) != "go-lxc" || info.Mode.Perm() != 0600 {
+		t.Errorf("unexpected file %q %+v", content, info)
+	}
 }
 
-func TestDestroy(t *testing.T) {
-	if supported("overlayfs") || supported("overlay") {
-		c, err := NewContainer(ContainerCloneOverlayName())
+func TestIDMapToHost(t *testing.T) {
+	m := parseIDMap([]string{"u 0 100000 65536", "g 0 200000 65536"})
+
+	if uid := m.toHost("u", 33); uid != 100033 {
+		t.Errorf("unexpected host UID %d", uid)
+	}
+
+	if gid := m.toHost("g", 33); gid != 200033 {
+		t.Errorf("unexpected host GID %d", gid)
+	}
+
+	if gid := m.toContainer("g", 200033); gid != 33 {
+		t.Errorf("unexpected container GID %d", gid)
+	}
+
+	if uid := m.toHost("u", 70000); uid != -1 {
+		t.Errorf("expected unmapped UID, got %d", uid)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	content := []byte("go-lxc")
+
+	var gz, zs, x bytes.Buffer
+
+	gw := gzip.NewWriter(&gz)
+	gw.Write(content)
+	gw.Close()
+
+	zw, _ := zstd.NewWriter(&zs)
+	zw.Write(content)
+	zw.Close()
+
+	xw, _ := xz.NewWriter(&x)
+	xw.Write(content)
+	xw.Close()
+
+	for name, compressed := range map[string][]byte{
+		"none": content,
+		"gzip": gz.Bytes(),
+		"zstd": zs.Bytes(),
+		"xz":   x.Bytes(),
+	} {
+		r, err := decompress(bytes.NewReader(compressed))
 		if err != nil {
-			t.Errorf(err.Error())
+			t.Errorf("%s: %s", name, err)
+			continue
 		}
-		defer c.Release()
 
-		if err := c.Destroy(); err != nil {
-			t.Errorf(err.Error())
+		decompressed, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(decompressed, content) {
+			t.Errorf("%s: unexpected content %q (%v)", name, decompressed, err)
 		}
 	}
+}
 
-	c, err := NewContainer(ContainerCloneName())
+func TestExtractTarShiftIDs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("skipping test as changing ownership requires root.")
+	}
+
+	dir, err := ioutil.TempDir("", "go-lxc-extract")
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Fatalf(err.Error())
 	}
-	defer c.Release()
+	defer os.RemoveAll(dir)
 
-	if err := c.Destroy(); err != nil {
-		t.Errorf(err.Error())
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/shadow", Typeflag: tar.TypeReg, Mode: 0640, Gid: 42},
+	} {
+		tw.WriteHeader(hdr)
 	}
+	tw.Close()
 
-	c, err = NewContainer(ContainerRestoreName())
+	ids := parseIDMap([]string{"u 0 100000 65536", "g 0 100000 65536"})
+	if err := extractTar(tar.NewReader(bytes.NewReader(buf.Bytes())), dir, ids, false); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	info, err := os.Lstat(filepath.Join(dir, "etc", "shadow"))
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Fatalf(err.Error())
 	}
-	defer c.Release()
 
-	if c.Defined() {
-		if err := c.Destroy(); err != nil {
-			t.Errorf(err.Error())
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Uid != 100000 || stat.Gid != 100042 {
+		t.Errorf("expected shifted ownership, got %d:%d", stat.Uid, stat.Gid)
+	}
+
+	// IDs outside of the map can't be represented.
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "nobody", Typeflag: tar.TypeReg, Mode: 0644, Uid: 70000})
+	tw.Close()
+
+	if err := extractTar(tar.NewReader(bytes.NewReader(buf.Bytes())), dir, ids, false); err == nil {
+		t.Errorf("expected unmapped IDs to be refused")
+	}
+}
+
+// writeOCILayout writes an image with the given layers to an OCI image layout.
+func writeOCILayout(t *testing.T, layout string, ref string, config ocispec.ImageConfig, layers ...[]byte) {
+	writeBlob := func(content []byte, mediaType string) ocispec.Descriptor {
+		d := digest.FromBytes(content)
+
+		dir := filepath.Join(layout, ocispec.ImageBlobsDir, d.Algorithm().String())
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, d.Encoded()), content, 0644); err != nil {
+			t.Fatalf(err.Error())
 		}
+
+		return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(content))}
 	}
 
-	c, err = NewContainer(ContainerName())
-	if err != nil {
-		t.Errorf(err.Error())
+	writeJSON := func(v interface{}, mediaType string) ocispec.Descriptor {
+		content, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		return writeBlob(content, mediaType)
 	}
-	defer c.Release()
 
-	if err := c.Destroy(); err != nil {
-		t.Errorf(err.Error())
+	image := ocispec.Image{Config: config}
+	image.OS = "linux"
+	image.Architecture = runtime.GOARCH
+
+	manifest := ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest}
+	manifest.SchemaVersion = 2
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, writeBlob(layer, ocispec.MediaTypeImageLayer))
+		image.RootFS.DiffIDs = append(image.RootFS.DiffIDs, digest.FromBytes(layer))
+	}
+	manifest.Config = writeJSON(image, ocispec.MediaTypeImageConfig)
+
+	desc := writeJSON(manifest, ocispec.MediaTypeImageManifest)
+	desc.Annotations = map[string]string{ocispec.AnnotationRefName: ref}
+
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: []ocispec.Descriptor{desc}}
+	index.SchemaVersion = 2
+
+	for name, v := range map[string]interface{}{
+		ocispec.ImageLayoutFile: ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion},
+		ocispec.ImageIndexFile:  index,
+	} {
+		content, _ := json.Marshal(v)
+		if err := ioutil.WriteFile(filepath.Join(layout, name), content, 0644); err != nil {
+			t.Fatalf(err.Error())
+		}
 	}
 }
 
-func TestConcurrentDestroy(t *testing.T) {
-	t.Skip("Skipping concurrent tests for now")
+func TestReadOCIImage(t *testing.T) {
+	layout, err := ioutil.TempDir("", "go-lxc-oci")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(layout)
 
-	defer runtime.GOMAXPROCS(runtime.NumCPU())
+	writeOCILayout(t, layout, "latest", ocispec.ImageConfig{Cmd: []string{"/bin/true"}}, []byte("layer"))
 
-	var wg sync.WaitGroup
+	for _, ref := range []string{"latest", ""} {
+		image, err := readOCIImage(layout, ref)
+		if err != nil {
+			t.Errorf("%q: %s", ref, err)
+			continue
+		}
 
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func(i int) {
-			c, err := NewContainer(strconv.Itoa(i))
-			if err != nil {
-				t.Errorf(err.Error())
-			}
-			defer c.Release()
+		if len(image.manifest.Layers) != 1 || image.config.Config.Cmd[0] != "/bin/true" {
+			t.Errorf("%q: unexpected image %+v", ref, image)
+		}
+	}
 
-			// sleep for a while to simulate some work
-			time.Sleep(time.Millisecond * time.Duration(rand.Intn(250)))
+	if _, err := readOCIImage(layout, "missing"); err == nil {
+		t.Errorf("expected a missing reference to fail")
+	}
 
-			if err := c.Destroy(); err != nil {
-				t.Errorf(err.Error())
-			}
-			wg.Done()
-		}(i)
+	// Corrupt the layer blob.
+	image, _ := readOCIImage(layout, "latest")
+	layer := image.manifest.Layers[0]
+	path := filepath.Join(layout, ocispec.ImageBlobsDir, layer.Digest.Algorithm().String(), layer.Digest.Encoded())
+	if err := ioutil.WriteFile(path, []byte("tampered"), 0644); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	root, _ := ioutil.TempDir("", "go-lxc-oci-root")
+	defer os.RemoveAll(root)
+
+	if err := applyOCILayer(layout, layer, root, nil); err == nil {
+		t.Errorf("expected a digest mismatch")
 	}
-	wg.Wait()
 }
 
-func TestBackendStore(t *testing.T) {
-	var X struct {
-		store BackendStore
+func TestApplyWhiteouts(t *testing.T) {
+	root, err := ioutil.TempDir("", "go-lxc-layers")
+	if err != nil {
+		t.Fatalf(err.Error())
 	}
+	defer os.RemoveAll(root)
 
-	if X.store.String() != "" {
-		t.Error("zero value of BackendStore should be invalid")
+	layer := func(entries ...*tar.Header) *tar.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range entries {
+			hdr.Uid, hdr.Gid = os.Getuid(), os.Getgid()
+			tw.WriteHeader(hdr)
+		}
+		tw.Close()
+		return tar.NewReader(&buf)
+	}
+
+	lower := layer(
+		&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "etc/removed", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "etc/kept", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "opaque/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "opaque/hidden", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/"},
+	)
+	if err := extractTar(lower, root, nil, true); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	upper := layer(
+		&tar.Header{Name: "etc/.wh.removed", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "opaque/new", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "opaque/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "escape/go-lxc", Typeflag: tar.TypeReg, Mode: 0644},
+	)
+	if err := extractTar(upper, root, nil, true); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for path, exists := range map[string]bool{
+		"etc/removed":         false,
+		"etc/.wh.removed":     false,
+		"etc/kept":            true,
+		"opaque/hidden":       false,
+		"opaque/new":          true,
+		"opaque/.wh..wh..opq": false,
+		"go-lxc":              true,
+	} {
+		if _, err := os.Lstat(filepath.Join(root, path)); (err == nil) != exists {
+			t.Errorf("expected %s to exist: %v", path, exists)
+		}
 	}
 }
 
-func TestState(t *testing.T) {
-	var X struct {
-		state State
+func TestOCIConfigItems(t *testing.T) {
+	items, err := ociConfigItems(ocispec.ImageConfig{
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Cmd:        []string{"echo 'go-lxc'"},
+		Env:        []string{"PATH=/bin", "GO_LXC=1"},
+		WorkingDir: "/srv",
+		User:       "www-data",
+		StopSignal: "SIGQUIT",
+	}, 33, 33)
+	if err != nil {
+		t.Fatalf(err.Error())
 	}
 
-	if X.state.String() != "" {
-		t.Error("zero value of State should be invalid")
+	expected := [][2]string{
+		{"lxc.execute.cmd", `/bin/sh -c "echo 'go-lxc'"`},
+		{"lxc.init.cmd", `/bin/sh -c "echo 'go-lxc'"`},
+		{"lxc.init.cwd", "/srv"},
+		{"lxc.init.uid", "33"},
+		{"lxc.init.gid", "33"},
+		{"lxc.environment", "PATH=/bin"},
+		{"lxc.environment", "GO_LXC=1"},
+		{"lxc.signal.halt", "SIGQUIT"},
+	}
+
+	if fmt.Sprint(items) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, items)
+	}
+
+	if _, err := quoteCommand([]string{`'"`}); err == nil {
+		t.Errorf("expected mixed quotes to be refused")
+	}
+
+	if cmd, _ := quoteCommand([]string{"ls", "", "a b"}); cmd != "ls '' 'a b'" {
+		t.Errorf("unexpected command %q", cmd)
 	}
 }
 
-func TestSupportedConfigItems(t *testing.T) {
-	if VersionAtLeast(2, 1, 0) {
-		if !IsSupportedConfigItem("lxc.arch") {
-			t.Errorf("IsSupportedConfigItem failed to detect \"lxc.arch\" as supported config item...")
-		}
+func TestOCIUser(t *testing.T) {
+	root, err := ioutil.TempDir("", "go-lxc-oci-root")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(root)
 
-		if IsSupportedConfigItem("lxc.nonsense") {
-			t.Errorf("IsSupportedConfigItem failed to detect \"lxc.nonsense\" as unsupported config item...")
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "etc", "passwd"), []byte("root:x:0:0::/root:/bin/sh\nwww-data:x:33:34::/var/www:/bin/false\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "etc", "group"), []byte("root:x:0:\nadm:x:4:\n"), 0644)
+
+	for _, v := range []struct {
+		user     string
+		uid, gid int
+	}{
+		{"", 0, 0},
+		{"www-data", 33, 34},
+		{"33", 33, 34},
+		{"1000", 1000, 0},
+		{"www-data:adm", 33, 4},
+		{"1000:1000", 1000, 1000},
+	} {
+		uid, gid, err := ociUser(root, v.user)
+		if err != nil || uid != v.uid || gid != v.gid {
+			t.Errorf("%q: expected %d:%d, got %d:%d (%v)", v.user, v.uid, v.gid, uid, gid, err)
 		}
 	}
+
+	if _, _, err := ociUser(root, "missing"); err == nil {
+		t.Errorf("expected an unknown user to fail")
+	}
 }
 
-func TestRuntimeLiblxcVersionAtLeast(t *testing.T) {
-	type args struct {
-		version string
-		major   int
-		minor   int
-		micro   int
+func TestTranslateOCISpec(t *testing.T) {
+	bundle, err := ioutil.TempDir("", "go-lxc-bundle")
+	if err != nil {
+		t.Fatalf(err.Error())
 	}
-	tests := []struct {
-		name string
-		args args
-		want bool
-	}{
-		{
-			name: "Check 5.0.0 is at least 2.1.0 returns true",
-			args: args{
-				version: "5.0.0",
-				major:   2,
-				minor:   1,
-				micro:   0,
+	defer os.RemoveAll(bundle)
+
+	// /proc and /sys are only populated once mounted.
+	if err := os.MkdirAll(filepath.Join(bundle, "rootfs", "etc", "secrets"), 0755); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(bundle, "rootfs", "etc", "shadow"), nil, 0600); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	limit := int64(256 * 1024 * 1024)
+	spec := &specs.Spec{
+		Root:       &specs.Root{Path: "rootfs", Readonly: true},
+		Hostname:   "go-lxc",
+		Domainname: "example.com",
+		Process: &specs.Process{
+			Args: []string{"/bin/sh", "-c", "echo go-lxc"},
+			Env:  []string{"PATH=/bin"},
+			Cwd:  "/",
+			User: specs.User{UID: 1000, GID: 1000},
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  []string{"CAP_CHOWN", "CAP_KILL"},
+				Effective: []string{"CAP_KILL"},
+				Permitted: []string{"CAP_KILL", "CAP_CHOWN"},
 			},
-			want: true,
+			Rlimits:         []specs.POSIXRlimit{{Type: "RLIMIT_NOFILE", Soft: 1024, Hard: ^uint64(0)}},
+			NoNewPrivileges: true,
 		},
-		{
-			name: "Check 5.0.0-devel is at least 2.1.0",
-			args: args{
-				version: "5.0.0-devel",
-				major:   2,
-				minor:   1,
-				micro:   0,
-			},
-			want: true,
+		Mounts: []specs.Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"ro"}},
+			{Destination: "/data dir", Source: "/srv", Options: []string{"rbind", "ro"}},
+			{Destination: "/run", Type: "tmpfs", Options: []string{"size=64m"}},
 		},
-		{
-			name: "Check 5.0.0~git2209-g5a7b9ce67-0ubuntu1 is at least 2.1.0",
-			args: args{
-				version: "5.0.0~git2209-g5a7b9ce67-0ubuntu1",
-				major:   2,
-				minor:   1,
-				micro:   0,
+		Hooks: &specs.Hooks{
+			Prestart:  []specs.Hook{{Path: "/usr/bin/hook", Args: []string{"hook", "it's"}, Env: []string{"A=1"}}},
+			Poststart: []specs.Hook{{Path: "/usr/bin/hook"}},
+		},
+		Linux: &specs.Linux{
+			UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			Sysctl:      map[string]string{"net.ipv4.ip_forward": "1"},
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.PIDNamespace},
+				{Type: specs.MountNamespace},
+				{Type: specs.NetworkNamespace, Path: "/proc/1/ns/net"},
 			},
-			want: true,
+			Resources: &specs.LinuxResources{
+				Memory:  &specs.LinuxMemory{Limit: &limit},
+				Pids:    &specs.LinuxPids{Limit: 100},
+				Network: &specs.LinuxNetwork{},
+			},
+			MaskedPaths:   []string{"/proc/acpi", "/proc/kcore", "/etc/secrets", "/etc/shadow"},
+			ReadonlyPaths: []string{"/proc/sys"},
+			IntelRdt:      &specs.LinuxIntelRdt{},
 		},
-		{
-			name: "Check 1.0.0 is not at least 2.1.0 returns true",
-			args: args{
-				version: "1.0.0",
-				major:   2,
-				minor:   1,
-				micro:   0,
+	}
+
+	config, err := TranslateOCISpec(spec, bundle)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rootfs := filepath.Join(bundle, "rootfs")
+	memory := "memory.limit_in_bytes"
+	if cgroup2() {
+		memory = "memory.max"
+	}
+
+	expected := []ConfigItem{
+		{"lxc.uts.name", "go-lxc"},
+		{"lxc.rootfs.path", "dir:" + rootfs},
+		{"lxc.rootfs.options", "ro"},
+		{"lxc.execute.cmd", "/bin/sh -c 'echo go-lxc'"},
+		{"lxc.init.cmd", "/bin/sh -c 'echo go-lxc'"},
+		{"lxc.init.cwd", "/"},
+		{"lxc.init.uid", "1000"},
+		{"lxc.init.gid", "1000"},
+		{"lxc.environment", "PATH=/bin"},
+		{"lxc.cap.keep", "chown kill"},
+		{"lxc.prlimit.nofile", "1024:unlimited"},
+		{"lxc.no_new_privs", "1"},
+		{"lxc.mount.entry", "/srv data\\040dir none rbind,ro,create=dir 0 0"},
+		{"lxc.mount.entry", "tmpfs run tmpfs size=64m 0 0"},
+		{"lxc.mount.auto", "proc:rw sys:ro"},
+		{"lxc.hook.version", "1"},
+		{"lxc.hook.start-host", "env 'A=1' '/usr/bin/hook' 'it'\\''s'"},
+		{"lxc.idmap", "u 0 100000 65536"},
+		{"lxc.namespace.share.net", "/proc/1/ns/net"},
+		{"lxc.namespace.clone", "pid mnt"},
+		{"lxc.sysctl.net.ipv4.ip_forward", "1"},
+		{"lxc.mount.entry", "tmpfs proc/acpi tmpfs ro,optional 0 0"},
+		{"lxc.mount.entry", "/dev/null proc/acpi none bind,optional 0 0"},
+		{"lxc.mount.entry", "tmpfs proc/kcore tmpfs ro,optional 0 0"},
+		{"lxc.mount.entry", "/dev/null proc/kcore none bind,optional 0 0"},
+		{"lxc.mount.entry", "tmpfs etc/secrets tmpfs ro,optional 0 0"},
+		{"lxc.mount.entry", "/dev/null etc/shadow none bind,optional 0 0"},
+		{"lxc.mount.entry", "proc/sys proc/sys none bind,ro,relative,optional 0 0"},
+		{cgroupConfigPrefix() + "." + memory, "268435456"},
+		{cgroupConfigPrefix() + ".pids.max", "100"},
+	}
+
+	if fmt.Sprint(config.Items) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, config.Items)
+	}
+
+	unsupported := []string{
+		"domainname",
+		"hooks.poststart",
+		"linux.intelRdt",
+		"linux.resources.network",
+		"process.capabilities.effective",
+	}
+
+	if fmt.Sprint(config.Unsupported) != fmt.Sprint(unsupported) {
+		t.Errorf("expected %q, got %q", unsupported, config.Unsupported)
+	}
+
+	if _, err := TranslateOCISpec(&specs.Spec{}, bundle); err == nil {
+		t.Errorf("expected a spec without root to fail")
+	}
+}
+
+func TestTranslateOCISeccomp(t *testing.T) {
+	errno := uint(38)
+	config, err := TranslateOCISpec(&specs.Spec{
+		Root: &specs.Root{Path: "/"},
+		Linux: &specs.Linux{
+			Seccomp: &specs.LinuxSeccomp{
+				DefaultAction:   specs.ActErrno,
+				DefaultErrnoRet: &errno,
+				Syscalls: []specs.LinuxSyscall{
+					{Names: []string{"read", "write"}, Action: specs.ActAllow},
+					{Names: []string{"personality"}, Action: specs.ActAllow, Args: []specs.LinuxSeccompArg{{Index: 0, Value: 8, Op: specs.OpEqualTo}}},
+					{Names: []string{"ptrace"}, Action: specs.ActTrace},
+				},
+				Flags:         []specs.LinuxSeccompFlag{specs.LinuxSeccompFlagLog},
+				Architectures: []specs.Arch{specs.ArchX86_64, specs.ArchX86},
 			},
-			want: false,
 		},
+	}, "/")
+	if err != nil {
+		t.Fatalf(err.Error())
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := RuntimeLiblxcVersionAtLeast(tt.args.version, tt.args.major, tt.args.minor, tt.args.micro); got != tt.want {
-				t.Errorf("RuntimeLiblxcVersionAtLeast() = %v, want %v", got, tt.want)
-			}
-		})
+
+	expected := "2\nallowlist errno 38\nread allow\nwrite allow\npersonality allow [0,8,SCMP_CMP_EQ,0]\n"
+	if config.SeccompProfile != expected {
+		t.Errorf("expected %q, got %q", expected, config.SeccompProfile)
+	}
+
+	unsupported := []string{"linux.seccomp.architectures", "linux.seccomp.flags", "linux.seccomp.syscalls[2].action"}
+	if fmt.Sprint(config.Unsupported) != fmt.Sprint(unsupported) {
+		t.Errorf("expected %q, got %q", unsupported, config.Unsupported)
+	}
+}
+
+func TestTranslateOCIResources(t *testing.T) {
+	shares := uint64(1024)
+	resources := &specs.LinuxResources{CPU: &specs.LinuxCPU{Shares: &shares}}
+
+	for _, v := range []struct {
+		unified  bool
+		expected string
+	}{
+		{false, "[{lxc.cgroup.cpu.shares 1024}]"},
+		{true, "[{lxc.cgroup2.cpu.weight 39}]"},
+	} {
+		translator := &ociTranslator{unified: v.unified, config: &OCISpecConfig{}}
+		translator.resources(resources)
+
+		if items := fmt.Sprint(translator.config.Items); items != v.expected {
+			t.Errorf("expected %s, got %s", v.expected, items)
+		}
+	}
+
+	// liblxc only mounts /proc read-write.
+	config, err := TranslateOCISpec(&specs.Spec{
+		Root:   &specs.Root{Path: "/"},
+		Mounts: []specs.Mount{{Destination: "/proc", Type: "proc", Source: "proc", Options: []string{"ro", "nosuid"}}},
+	}, "/")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := []ConfigItem{
+		{"lxc.rootfs.path", "dir:/"},
+		{"lxc.mount.entry", "proc proc proc ro,nosuid 0 0"},
+	}
+	if fmt.Sprint(config.Items) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, config.Items)
+	}
+}
+
+func TestImageCache(t *testing.T) {
+	base, err := ioutil.TempDir("", "go-lxc-cache")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(base)
+
+	cache := ImageCache(base)
+
+	if images, err := cache.Images(); err != nil || len(images) != 0 {
+		t.Errorf("expected an empty cache, got %v (%v)", images, err)
+	}
+
+	for _, image := range []struct {
+		path    string
+		buildID string
+	}{
+		{"ubuntu/jammy/amd64/default", time.Now().UTC().Format(buildIDLayout)},
+		{"alpine/3.18/amd64/default", "20200101_13:00"},
+		{"alpine/3.18/arm64/default", "20200101_13:00"},
+	} {
+		dir := filepath.Join(cache.Path(), image.path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, "build_id"), []byte(image.buildID+"\n"), 0644); err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, "rootfs.tar.xz"), make([]byte, 1024), 0644); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	// Incomplete downloads aren't images.
+	if err := os.MkdirAll(filepath.Join(cache.Path(), "debian", "bookworm", "amd64", "default"), 0755); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	images, err := cache.Images()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(images) != 3 {
+		t.Fatalf("expected 3 images, got %v", images)
+	}
+
+	image := images[0]
+	if image.Distribution != "alpine" || image.Release != "3.18" || image.Arch != "amd64" || image.Variant != "default" {
+		t.Errorf("unexpected image %+v", image)
+	}
+
+	if !image.Built.Equal(time.Date(2020, 1, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected build time %s", image.Built)
+	}
+
+	if image.Size != ByteSize(1024+len("20200101_13:00\n")) {
+		t.Errorf("unexpected size %s", image.Size)
+	}
+
+	if err := cache.Remove(CachedImage{Distribution: "..", Release: "..", Arch: "..", Variant: ".."}); err != ErrImageNotCached {
+		t.Errorf("expected ErrImageNotCached, got %v", err)
+	}
+
+	if err := cache.Remove(images[1]); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if err := cache.Remove(images[1]); err != ErrImageNotCached {
+		t.Errorf("expected ErrImageNotCached, got %v", err)
+	}
+
+	pruned, err := cache.Prune(24 * time.Hour)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(pruned) != 1 || pruned[0].Distribution != "alpine" {
+		t.Errorf("unexpected pruned images %v", pruned)
+	}
+
+	if _, err := os.Stat(filepath.Join(cache.Path(), "alpine")); !os.IsNotExist(err) {
+		t.Errorf("expected the empty directories to be removed")
+	}
+
+	images, err = cache.Images()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(images) != 1 || images[0].Distribution != "ubuntu" {
+		t.Errorf("unexpected images %v", images)
 	}
 }
@@ -0,0 +1,204 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+// #include <lxc/lxccontainer.h>
+// #include <lxc/version.h>
+// #include "lxc-binding.h"
+import "C"
+
+import (
+	"fmt"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mount mounts source on target inside the running container. source is a
+// path on the host for bind mounts and is otherwise interpreted by the
+// filesystem, target has to be an existing absolute path in the container.
+// liblxc's mount injection is used when the mount_injection_file API
+// extension is available, the container's mount namespace is entered
+// directly otherwise.
+func (c *Container) Mount(source string, target string, options MountOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.container == nil {
+		return ErrNotDefined
+	}
+
+	if err := c.makeSure(isRunning); err != nil {
+		return err
+	}
+
+	if !filepath.IsAbs(target) {
+		return fmt.Errorf("%s: target %q isn't an absolute path", ErrMountFailed, target)
+	}
+
+	flags := options.Flags
+	if options.FSType == "" {
+		flags |= unix.MS_BIND
+	}
+	if options.ReadOnly {
+		flags |= unix.MS_RDONLY
+	}
+	bind := flags&unix.MS_BIND != 0
+
+	var err error
+	if HasAPIExtension("mount_injection_file") {
+		err = c.injectMount(source, target, options.FSType, flags, options.Data)
+	} else if bind {
+		err = c.bindMountNamespace(source, target, flags)
+	} else {
+		err = c.mountNamespace(source, target, options.FSType, flags, options.Data, false)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrMountFailed, err)
+	}
+
+	// The kernel ignores MS_RDONLY when creating a bind mount, it only
+	// applies to a remount.
+	if bind && options.ReadOnly {
+		remount := unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY | flags&(unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC)
+		if err := c.mountNamespace("", target, "", remount, "", false); err != nil {
+			_ = c.mountNamespace("", target, "", unix.MNT_DETACH, "", true)
+			return fmt.Errorf("%s: %s", ErrMountFailed, err)
+		}
+	}
+
+	return nil
+}
+
+// Unmount lazily unmounts target inside the running container.
+func (c *Container) Unmount(target string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.container == nil {
+		return ErrNotDefined
+	}
+
+	if err := c.makeSure(isRunning); err != nil {
+		return err
+	}
+
+	if !filepath.IsAbs(target) {
+		return fmt.Errorf("%s: target %q isn't an absolute path", ErrUnmountFailed, target)
+	}
+
+	var err error
+	if HasAPIExtension("mount_injection_file") {
+		ctarget := C.CString(target)
+		defer C.free(unsafe.Pointer(ctarget))
+
+		if ret := C.go_lxc_umount(c.container, ctarget, C.ulong(unix.MNT_DETACH)); ret < 0 {
+			err = unix.Errno(-ret)
+		}
+	} else {
+		err = c.mountNamespace("", target, "", unix.MNT_DETACH, "", true)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrUnmountFailed, err)
+	}
+
+	return nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) injectMount(source string, target string, fstype string, flags uintptr, data string) error {
+	csource := C.CString(source)
+	defer C.free(unsafe.Pointer(csource))
+
+	ctarget := C.CString(target)
+	defer C.free(unsafe.Pointer(ctarget))
+
+	var cfstype, cdata *C.char
+	if fstype != "" {
+		cfstype = C.CString(fstype)
+		defer C.free(unsafe.Pointer(cfstype))
+	}
+	if data != "" {
+		cdata = C.CString(data)
+		defer C.free(unsafe.Pointer(cdata))
+	}
+
+	if ret := C.go_lxc_mount(c.container, csource, ctarget, cfstype, C.ulong(flags), cdata); ret < 0 {
+		return unix.Errno(-ret)
+	}
+	return nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) bindMountNamespace(source string, target string, flags uintptr) error {
+	// The host path isn't reachable from within the container, so a
+	// detached copy of it is created here and attached in the namespace.
+	cloneFlags := uint(unix.OPEN_TREE_CLONE | unix.OPEN_TREE_CLOEXEC)
+	if flags&unix.MS_REC != 0 {
+		cloneFlags |= unix.AT_RECURSIVE
+	}
+
+	tree, err := unix.OpenTree(unix.AT_FDCWD, source, cloneFlags)
+	if err != nil {
+		if err == unix.ENOSYS {
+			return ErrNotSupported
+		}
+		return err
+	}
+	defer unix.Close(tree)
+
+	return c.mountNamespaceFd(tree, "", target, "", 0, "", false)
+}
+
+// Caller needs to hold the lock
+func (c *Container) mountNamespace(source string, target string, fstype string, flags uintptr, data string, unmount bool) error {
+	return c.mountNamespaceFd(-1, source, target, fstype, flags, data, unmount)
+}
+
+// Caller needs to hold the lock
+func (c *Container) mountNamespaceFd(tree int, source string, target string, fstype string, flags uintptr, data string, unmount bool) error {
+	// The pidfd makes sure /proc/<pid> still refers to the container's init
+	// process once its namespace is opened.
+	pidfd := int(C.go_lxc_init_pidfd(c.container))
+	if pidfd < 0 {
+		return unix.Errno(unix.EBADF)
+	}
+	defer unix.Close(pidfd)
+
+	ns, err := unix.Open(fmt.Sprintf("/proc/%d/ns/mnt", c.initPid()), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(ns)
+
+	if err := unix.PidfdSendSignal(pidfd, 0, nil, 0); err != nil {
+		return fmt.Errorf("%s: %q", ErrNotRunning, c.name())
+	}
+
+	csource := C.CString(source)
+	defer C.free(unsafe.Pointer(csource))
+
+	ctarget := C.CString(target)
+	defer C.free(unsafe.Pointer(ctarget))
+
+	var cfstype, cdata *C.char
+	if fstype != "" {
+		cfstype = C.CString(fstype)
+		defer C.free(unsafe.Pointer(cfstype))
+	}
+	if data != "" {
+		cdata = C.CString(data)
+		defer C.free(unsafe.Pointer(cdata))
+	}
+
+	if ret := C.go_lxc_mount_ns(C.int(ns), C.int(tree), csource, ctarget, cfstype, C.ulong(flags), cdata, C.bool(unmount)); ret < 0 {
+		return unix.Errno(-ret)
+	}
+	return nil
+}
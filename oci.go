@@ -0,0 +1,467 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+// #include <lxc/lxccontainer.h>
+// #include <lxc/version.h>
+// #include "lxc-binding.h"
+import "C"
+
+import (
+	"archive/tar"
+	"bufio"
+	_ "crypto/sha256" // registers the digest algorithms
+	_ "crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ociImage is an image read from an OCI image layout.
+type ociImage struct {
+	layout   string
+	manifest ocispec.Manifest
+	config   ocispec.Image
+}
+
+// CreateFromOCI creates an application container named name in lxcpath from
+// the image ref of the OCI image layout in the layout directory. ref is
+// matched against the org.opencontainers.image.ref.name annotation and the
+// manifest digests, it can be empty when the layout holds a single image.
+// Multi-platform images are resolved for the host's architecture.
+//
+// The layers are applied to the root filesystem in order, honouring
+// whiteouts, and the image's entrypoint, command, environment, working
+// directory, user and stop signal are translated to the lxc.execute.cmd,
+// lxc.init.*, lxc.environment and lxc.signal.halt keys.
+func CreateFromOCI(name string, lxcpath string, layout string, ref string, options OCIOptions) (*Container, error) {
+	if !VersionAtLeast(3, 0, 0) {
+		return nil, ErrNotSupported
+	}
+
+	image, err := readOCIImage(layout, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if image.config.OS != "" && image.config.OS != "linux" {
+		return nil, fmt.Errorf("%s: unsupported operating system %q", ErrInvalidOCIImage, image.config.OS)
+	}
+
+	c, err := NewContainer(name, lxcpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.createFromOCI(image, options); err != nil {
+		c.Release()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Container) createFromOCI(image *ociImage, options OCIOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.makeSure(isNotDefined); err != nil {
+		return err
+	}
+
+	err := c.createWithoutTemplate(TemplateOptions{
+		Backend:      options.Backend,
+		BackendSpecs: options.BackendSpecs,
+		Arch:         lxcArch(image.config.Architecture),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.populateFromOCI(image); err != nil {
+		C.go_lxc_destroy(c.container)
+		return err
+	}
+
+	return nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) populateFromOCI(image *ociImage) error {
+	root, unmount, err := c.mountRootfs(false)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	ids := c.idmap()
+	for _, layer := range image.manifest.Layers {
+		if err := applyOCILayer(image.layout, layer, root, ids); err != nil {
+			return err
+		}
+	}
+
+	uid, gid, err := ociUser(root, image.config.Config.User)
+	if err != nil {
+		return err
+	}
+
+	items, err := ociConfigItems(image.config.Config, uid, gid)
+	if err != nil {
+		return err
+	}
+
+	// There's no distribution configuration to mount the API filesystems.
+	if c.configItem("lxc.mount.auto")[0] == "" {
+		items = append(items, [2]string{"lxc.mount.auto", "proc:mixed sys:mixed cgroup:mixed"})
+	}
+
+	for _, item := range items {
+		if err := c.setConfigItem(item[0], item[1]); err != nil {
+			return err
+		}
+	}
+
+	return c.saveConfigFile(c.configFileName())
+}
+
+// readOCIImage reads the manifest and configuration of ref from the layout.
+func readOCIImage(layout string, ref string) (*ociImage, error) {
+	var imageLayout ocispec.ImageLayout
+	content, err := ioutil.ReadFile(filepath.Join(layout, ocispec.ImageLayoutFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidOCIImage, err)
+	}
+
+	if err := json.Unmarshal(content, &imageLayout); err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidOCIImage, err)
+	}
+
+	if imageLayout.Version != ocispec.ImageLayoutVersion {
+		return nil, fmt.Errorf("%s: unsupported layout version %q", ErrInvalidOCIImage, imageLayout.Version)
+	}
+
+	var index ocispec.Index
+	content, err = ioutil.ReadFile(filepath.Join(layout, ocispec.ImageIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidOCIImage, err)
+	}
+
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidOCIImage, err)
+	}
+
+	desc, err := resolveOCIRef(index, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// Follow image indexes down to the manifest for this platform.
+	for desc.MediaType == ocispec.MediaTypeImageIndex {
+		var nested ocispec.Index
+		if err := readOCIBlob(layout, desc, &nested); err != nil {
+			return nil, err
+		}
+
+		if desc, err = selectOCIPlatform(nested.Manifests, runtime.GOARCH); err != nil {
+			return nil, err
+		}
+	}
+
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		return nil, fmt.Errorf("%s: unsupported media type %q", ErrInvalidOCIImage, desc.MediaType)
+	}
+
+	image := &ociImage{layout: layout}
+	if err := readOCIBlob(layout, desc, &image.manifest); err != nil {
+		return nil, err
+	}
+
+	if err := readOCIBlob(layout, image.manifest.Config, &image.config); err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// resolveOCIRef returns the descriptor of ref in the layout's index.
+func resolveOCIRef(index ocispec.Index, ref string) (ocispec.Descriptor, error) {
+	if ref == "" {
+		if len(index.Manifests) != 1 {
+			return ocispec.Descriptor{}, fmt.Errorf("%s: a reference is required to choose among %d images", ErrInvalidOCIImage, len(index.Manifests))
+		}
+		return index.Manifests[0], nil
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Annotations[ocispec.AnnotationRefName] == ref || desc.Digest.String() == ref {
+			return desc, nil
+		}
+	}
+
+	return ocispec.Descriptor{}, fmt.Errorf("%s: reference %q not found", ErrInvalidOCIImage, ref)
+}
+
+// selectOCIPlatform returns the linux manifest for arch among manifests.
+func selectOCIPlatform(manifests []ocispec.Descriptor, arch string) (ocispec.Descriptor, error) {
+	for _, desc := range manifests {
+		if desc.Platform == nil {
+			continue
+		}
+
+		if desc.Platform.OS == "linux" && desc.Platform.Architecture == arch {
+			return desc, nil
+		}
+	}
+
+	return ocispec.Descriptor{}, fmt.Errorf("%s: no image for linux/%s", ErrInvalidOCIImage, arch)
+}
+
+// openOCIBlob opens the blob of desc in the layout.
+func openOCIBlob(layout string, desc ocispec.Descriptor) (*os.File, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidOCIImage, err)
+	}
+
+	f, err := os.Open(filepath.Join(layout, ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidOCIImage, err)
+	}
+
+	return f, nil
+}
+
+// readOCIBlob decodes the JSON blob of desc, verifying its digest.
+func readOCIBlob(layout string, desc ocispec.Descriptor, v interface{}) error {
+	f, err := openOCIBlob(layout, desc)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	if desc.Digest.Algorithm().FromBytes(content) != desc.Digest {
+		return fmt.Errorf("%s: digest mismatch for %s", ErrInvalidOCIImage, desc.Digest)
+	}
+
+	return json.Unmarshal(content, v)
+}
+
+// applyOCILayer extracts the layer of desc on top of root, verifying its digest.
+func applyOCILayer(layout string, desc ocispec.Descriptor, root string, ids idmap) error {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageLayer, ocispec.MediaTypeImageLayerGzip, ocispec.MediaTypeImageLayerZstd,
+		ocispec.MediaTypeImageLayerNonDistributable, ocispec.MediaTypeImageLayerNonDistributableGzip, ocispec.MediaTypeImageLayerNonDistributableZstd,
+		"application/vnd.docker.image.rootfs.diff.tar.gzip":
+	default:
+		return fmt.Errorf("%s: unsupported layer media type %q", ErrInvalidOCIImage, desc.MediaType)
+	}
+
+	f, err := openOCIBlob(layout, desc)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	verifier := desc.Digest.Verifier()
+	r := io.TeeReader(f, verifier)
+
+	dr, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	if err := extractTar(tar.NewReader(dr), root, ids, true); err != nil {
+		return err
+	}
+
+	// The digest covers the whole blob, including any padding after the archive.
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+
+	if !verifier.Verified() {
+		return fmt.Errorf("%s: digest mismatch for %s", ErrInvalidOCIImage, desc.Digest)
+	}
+
+	return nil
+}
+
+// ociConfigItems translates the execution parameters of an image to
+// configuration items. uid and gid are those User resolved to.
+func ociConfigItems(config ocispec.ImageConfig, uid int, gid int) ([][2]string, error) {
+	var items [][2]string
+
+	args := append(append([]string{}, config.Entrypoint...), config.Cmd...)
+	if len(args) > 0 {
+		cmd, err := quoteCommand(args)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items,
+			[2]string{"lxc.execute.cmd", cmd},
+			[2]string{"lxc.init.cmd", cmd},
+		)
+	}
+
+	if config.WorkingDir != "" {
+		items = append(items, [2]string{"lxc.init.cwd", config.WorkingDir})
+	}
+
+	if config.User != "" {
+		items = append(items,
+			[2]string{"lxc.init.uid", strconv.Itoa(uid)},
+			[2]string{"lxc.init.gid", strconv.Itoa(gid)},
+		)
+	}
+
+	for _, env := range config.Env {
+		items = append(items, [2]string{"lxc.environment", env})
+	}
+
+	if config.StopSignal != "" {
+		items = append(items, [2]string{"lxc.signal.halt", config.StopSignal})
+	}
+
+	return items, nil
+}
+
+// quoteCommand joins args in the quoting understood by liblxc, which splits
+// commands on blanks outside of single or double quotes without escapes.
+func quoteCommand(args []string) (string, error) {
+	quoted := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		switch {
+		case arg != "" && !strings.ContainsAny(arg, " \t\n'\""):
+			quoted = append(quoted, arg)
+		case !strings.Contains(arg, "'"):
+			quoted = append(quoted, "'"+arg+"'")
+		case !strings.Contains(arg, "\""):
+			quoted = append(quoted, "\""+arg+"\"")
+		default:
+			return "", fmt.Errorf("%s: argument %q can't be quoted for liblxc", ErrInvalidOCIImage, arg)
+		}
+	}
+
+	return strings.Join(quoted, " "), nil
+}
+
+// ociUser resolves the user[:group] of an image config, names being looked
+// up in the root filesystem's /etc/passwd and /etc/group.
+func ociUser(root string, user string) (int, int, error) {
+	if user == "" {
+		return 0, 0, nil
+	}
+
+	name, group := user, ""
+	if i := strings.IndexByte(user, ':'); i >= 0 {
+		name, group = user[:i], user[i+1:]
+	}
+
+	uid, err := strconv.Atoi(name)
+	gid := 0
+	if err != nil {
+		entry, err := lookupIDFile(root, "/etc/passwd", name)
+		if err != nil {
+			return -1, -1, err
+		}
+
+		if uid, err = strconv.Atoi(entry[2]); err != nil {
+			return -1, -1, fmt.Errorf("%s: malformed passwd entry for %q", ErrInvalidOCIImage, name)
+		}
+		gid, _ = strconv.Atoi(entry[3])
+	} else if entry, err := lookupIDFile(root, "/etc/passwd", uid); err == nil {
+		// The primary group of numeric users is looked up as well.
+		gid, _ = strconv.Atoi(entry[3])
+	}
+
+	if group == "" {
+		return uid, gid, nil
+	}
+
+	if gid, err = strconv.Atoi(group); err == nil {
+		return uid, gid, nil
+	}
+
+	entry, err := lookupIDFile(root, "/etc/group", group)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	if gid, err = strconv.Atoi(entry[2]); err != nil {
+		return -1, -1, fmt.Errorf("%s: malformed group entry for %q", ErrInvalidOCIImage, group)
+	}
+
+	return uid, gid, nil
+}
+
+// lookupIDFile returns the fields of the entry of a passwd or group file in
+// the root filesystem matching key, a name or a numeric ID.
+func lookupIDFile(root string, name string, key interface{}) ([]string, error) {
+	path, err := secureJoin(root, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidOCIImage, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 4 {
+			continue
+		}
+
+		switch key := key.(type) {
+		case string:
+			if fields[0] == key {
+				return fields, nil
+			}
+		case int:
+			if fields[2] == strconv.Itoa(key) {
+				return fields, nil
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("%s: %v not found in %s", ErrInvalidOCIImage, key, name)
+}
+
+// lxcArch translates a GOARCH as used by OCI images to a lxc.arch value.
+func lxcArch(arch string) string {
+	switch arch {
+	case "386":
+		return "i386"
+	case "arm":
+		return "armhf"
+	}
+	return arch
+}
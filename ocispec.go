@@ -0,0 +1,997 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// ConfigItem is a key and value of a container's configuration.
+type ConfigItem struct {
+	Key   string
+	Value string
+}
+
+// OCISpecConfig is the LXC configuration translated from an OCI runtime spec.
+type OCISpecConfig struct {
+	// Items are the configuration items in the order they are applied.
+	// Keys can be repeated for list items such as lxc.mount.entry.
+	Items []ConfigItem
+
+	// SeccompProfile is the LXC seccomp policy translated from
+	// linux.seccomp, empty if the spec has none.
+	SeccompProfile string
+
+	// Unsupported lists the spec fields which are set but have no LXC
+	// equivalent, by their JSON path (e.g. "linux.intelRdt").
+	Unsupported []string
+}
+
+// TranslateOCISpec translates an OCI runtime spec to LXC configuration
+// items. bundle is the directory relative paths of the spec are resolved
+// against. Resource limits are translated for the host's cgroup hierarchy
+// and fields without LXC equivalent are listed in Unsupported rather than
+// failing the translation.
+//
+// Hooks are run by liblxc through the shell with lxc.hook.version set to 1,
+// they don't receive the container state on their standard input.
+func TranslateOCISpec(spec *specs.Spec, bundle string) (*OCISpecConfig, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("%s: empty spec", ErrInvalidOCISpec)
+	}
+
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &ociTranslator{bundle: bundle, unified: cgroup2(), config: &OCISpecConfig{}}
+
+	for field, set := range map[string]bool{
+		"domainname": spec.Domainname != "",
+		"solaris":    spec.Solaris != nil,
+		"windows":    spec.Windows != nil,
+		"vm":         spec.VM != nil,
+		"zos":        spec.ZOS != nil,
+	} {
+		if set {
+			t.unsupported(field)
+		}
+	}
+
+	if spec.Hostname != "" {
+		t.set("lxc.uts.name", spec.Hostname)
+	}
+
+	steps := []func(*specs.Spec) error{t.root, t.process, t.mounts, t.hooks, t.linux}
+	for _, step := range steps {
+		if err := step(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(t.config.Unsupported)
+	return t.config, nil
+}
+
+// ApplyOCISpec translates the OCI runtime spec with TranslateOCISpec and
+// applies the result to the container's configuration, which is saved. The
+// keys set by the spec replace any previous values, lxc.cap.drop is cleared
+// when capabilities are kept. The seccomp policy is written next to the
+// container's configuration file.
+func (c *Container) ApplyOCISpec(spec *specs.Spec, bundle string) (*OCISpecConfig, error) {
+	config, err := TranslateOCISpec(spec, bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.container == nil {
+		return nil, ErrNotDefined
+	}
+
+	if err := c.makeSure(isDefined | isNotRunning); err != nil {
+		return nil, err
+	}
+
+	items := config.Items
+	if config.SeccompProfile != "" {
+		profile := filepath.Join(c.configPath(), c.name(), "seccomp.profile")
+		if err := ioutil.WriteFile(profile, []byte(config.SeccompProfile), 0644); err != nil {
+			return nil, err
+		}
+		items = append(items, ConfigItem{"lxc.seccomp.profile", profile})
+	}
+
+	cleared := make(map[string]bool)
+	for _, item := range items {
+		if !cleared[item.Key] {
+			cleared[item.Key] = true
+
+			// lxc.cap.keep and lxc.cap.drop are mutually exclusive.
+			if item.Key == "lxc.cap.keep" {
+				if err := c.clearConfigItem("lxc.cap.drop"); err != nil {
+					return nil, fmt.Errorf("%s: %s", err, "lxc.cap.drop")
+				}
+			}
+
+			if err := c.clearConfigItem(item.Key); err != nil {
+				return nil, fmt.Errorf("%s: %s", err, item.Key)
+			}
+		}
+
+		if err := c.setConfigItem(item.Key, item.Value); err != nil {
+			return nil, fmt.Errorf("%s: %s=%q", err, item.Key, item.Value)
+		}
+	}
+
+	if err := c.saveConfigFile(c.configFileName()); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// ociTranslator accumulates the translation of a spec.
+type ociTranslator struct {
+	bundle  string
+	rootfs  string
+	unified bool
+	config  *OCISpecConfig
+}
+
+func (t *ociTranslator) set(key string, value string) {
+	t.config.Items = append(t.config.Items, ConfigItem{key, value})
+}
+
+func (t *ociTranslator) unsupported(field string) {
+	t.config.Unsupported = append(t.config.Unsupported, field)
+}
+
+// path resolves a path of the spec relative to the bundle.
+func (t *ociTranslator) path(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(t.bundle, path)
+}
+
+func (t *ociTranslator) root(spec *specs.Spec) error {
+	if spec.Root == nil || spec.Root.Path == "" {
+		return fmt.Errorf("%s: root.path is required", ErrInvalidOCISpec)
+	}
+
+	t.rootfs = t.path(spec.Root.Path)
+	t.set("lxc.rootfs.path", "dir:"+t.rootfs)
+
+	var options []string
+	if spec.Root.Readonly {
+		options = append(options, "ro")
+	}
+	if spec.Linux != nil && spec.Linux.RootfsPropagation != "" {
+		options = append(options, spec.Linux.RootfsPropagation)
+	}
+	if len(options) > 0 {
+		t.set("lxc.rootfs.options", strings.Join(options, ","))
+	}
+
+	return nil
+}
+
+func (t *ociTranslator) process(spec *specs.Spec) error {
+	p := spec.Process
+	if p == nil {
+		return nil
+	}
+
+	for field, set := range map[string]bool{
+		"process.consoleSize": p.ConsoleSize != nil,
+		"process.commandLine": p.CommandLine != "",
+		"process.user.umask":  p.User.Umask != nil,
+		"process.scheduler":   p.Scheduler != nil,
+		"process.ioPriority":  p.IOPriority != nil,
+	} {
+		if set {
+			t.unsupported(field)
+		}
+	}
+
+	if len(p.Args) > 0 {
+		cmd, err := quoteCommand(p.Args)
+		if err != nil {
+			return err
+		}
+		t.set("lxc.execute.cmd", cmd)
+		t.set("lxc.init.cmd", cmd)
+	}
+
+	if p.Cwd != "" {
+		t.set("lxc.init.cwd", p.Cwd)
+	}
+
+	t.set("lxc.init.uid", strconv.FormatUint(uint64(p.User.UID), 10))
+	t.set("lxc.init.gid", strconv.FormatUint(uint64(p.User.GID), 10))
+
+	if len(p.User.AdditionalGids) > 0 {
+		if IsSupportedConfigItem("lxc.init.groups") {
+			groups := make([]string, 0, len(p.User.AdditionalGids))
+			for _, gid := range p.User.AdditionalGids {
+				groups = append(groups, strconv.FormatUint(uint64(gid), 10))
+			}
+			t.set("lxc.init.groups", strings.Join(groups, ","))
+		} else {
+			t.unsupported("process.user.additionalGids")
+		}
+	}
+
+	for _, env := range p.Env {
+		t.set("lxc.environment", env)
+	}
+
+	if p.Capabilities != nil {
+		t.capabilities(p.Capabilities)
+	}
+
+	for _, rlimit := range p.Rlimits {
+		name := strings.ToLower(strings.TrimPrefix(rlimit.Type, "RLIMIT_"))
+		t.set("lxc.prlimit."+name, fmt.Sprintf("%s:%s", rlimitValue(rlimit.Soft), rlimitValue(rlimit.Hard)))
+	}
+
+	if p.NoNewPrivileges {
+		t.set("lxc.no_new_privs", "1")
+	}
+
+	if p.ApparmorProfile != "" {
+		t.set("lxc.apparmor.profile", p.ApparmorProfile)
+	}
+
+	if p.OOMScoreAdj != nil {
+		t.set("lxc.proc.oom_score_adj", strconv.Itoa(*p.OOMScoreAdj))
+	}
+
+	if p.SelinuxLabel != "" {
+		t.set("lxc.selinux.context", p.SelinuxLabel)
+	}
+
+	return nil
+}
+
+// rlimitValue formats a limit for lxc.prlimit.
+func rlimitValue(limit uint64) string {
+	if limit == ^uint64(0) {
+		return "unlimited"
+	}
+	return strconv.FormatUint(limit, 10)
+}
+
+// capabilities keeps the bounding set, liblxc can't set the other sets
+// independently so those which are set and differ are reported.
+func (t *ociTranslator) capabilities(caps *specs.LinuxCapabilities) {
+	names := make([]string, 0, len(caps.Bounding))
+	bounding := make(map[string]bool, len(caps.Bounding))
+	for _, capability := range caps.Bounding {
+		bounding[capability] = true
+		names = append(names, strings.ToLower(strings.TrimPrefix(capability, "CAP_")))
+	}
+
+	if len(names) == 0 {
+		names = append(names, "none")
+	}
+	t.set("lxc.cap.keep", strings.Join(names, " "))
+
+	for field, set := range map[string][]string{
+		"process.capabilities.effective":   caps.Effective,
+		"process.capabilities.inheritable": caps.Inheritable,
+		"process.capabilities.permitted":   caps.Permitted,
+	} {
+		if len(set) == 0 {
+			continue
+		}
+
+		if len(set) != len(bounding) {
+			t.unsupported(field)
+			continue
+		}
+
+		for _, capability := range set {
+			if !bounding[capability] {
+				t.unsupported(field)
+				break
+			}
+		}
+	}
+
+	if len(caps.Ambient) > 0 {
+		t.unsupported("process.capabilities.ambient")
+	}
+}
+
+// mountEntryEscape escapes a path the way fstab expects it.
+func mountEntryEscape(path string) string {
+	return strings.NewReplacer(" ", "\\040", "\t", "\\011", "\n", "\\012", "\\", "\\134").Replace(path)
+}
+
+func (t *ociTranslator) mountEntry(source string, destination string, fstype string, options []string) {
+	if fstype == "" {
+		fstype = "none"
+	}
+
+	if len(options) == 0 {
+		options = []string{"defaults"}
+	}
+
+	// Mount entries are relative to the root filesystem.
+	destination = strings.TrimPrefix(filepath.Clean("/"+destination), "/")
+	t.set("lxc.mount.entry", fmt.Sprintf("%s %s %s %s 0 0", mountEntryEscape(source), mountEntryEscape(destination), fstype, strings.Join(options, ",")))
+}
+
+func (t *ociTranslator) mounts(spec *specs.Spec) error {
+	var auto []string
+
+	for i, m := range spec.Mounts {
+		if len(m.UIDMappings) > 0 || len(m.GIDMappings) > 0 {
+			t.unsupported(fmt.Sprintf("mounts[%d].uidMappings", i))
+			continue
+		}
+
+		ro := "rw"
+		for _, option := range m.Options {
+			if option == "ro" {
+				ro = "ro"
+			}
+		}
+
+		// liblxc sets up the API filesystems itself.
+		switch {
+		case m.Destination == "/proc" && m.Type == "proc" && ro == "rw":
+			auto = append(auto, "proc:rw")
+			continue
+		case m.Destination == "/sys" && m.Type == "sysfs":
+			auto = append(auto, "sys:"+ro)
+			continue
+		case m.Destination == "/sys/fs/cgroup" && (m.Type == "cgroup" || m.Type == "cgroup2"):
+			auto = append(auto, "cgroup:"+ro)
+			continue
+		case m.Destination == "/dev" && m.Type == "tmpfs":
+			t.set("lxc.autodev", "1")
+			continue
+		case m.Destination == "/dev/pts" && m.Type == "devpts":
+			t.set("lxc.pty.max", "1024")
+			continue
+		}
+
+		options := append([]string{}, m.Options...)
+		source := m.Source
+
+		bind := false
+		for _, option := range options {
+			if option == "bind" || option == "rbind" {
+				bind = true
+			}
+		}
+
+		if bind {
+			source = t.path(source)
+
+			create := "create=dir"
+			if info, err := os.Stat(source); err == nil && !info.IsDir() {
+				create = "create=file"
+			}
+			options = append(options, create)
+		} else if source == "" {
+			source = m.Type
+		}
+
+		t.mountEntry(source, m.Destination, m.Type, options)
+	}
+
+	if len(auto) > 0 {
+		t.set("lxc.mount.auto", strings.Join(auto, " "))
+	}
+
+	return nil
+}
+
+func (t *ociTranslator) hooks(spec *specs.Spec) error {
+	if spec.Hooks == nil {
+		return nil
+	}
+
+	versioned := false
+	for _, v := range []struct {
+		field string
+		key   string
+		hooks []specs.Hook
+	}{
+		{"hooks.prestart", "lxc.hook.start-host", spec.Hooks.Prestart},
+		{"hooks.createRuntime", "lxc.hook.start-host", spec.Hooks.CreateRuntime},
+		{"hooks.createContainer", "lxc.hook.mount", spec.Hooks.CreateContainer},
+		{"hooks.startContainer", "lxc.hook.start", spec.Hooks.StartContainer},
+		{"hooks.poststart", "", spec.Hooks.Poststart},
+		{"hooks.poststop", "lxc.hook.post-stop", spec.Hooks.Poststop},
+	} {
+		if len(v.hooks) == 0 {
+			continue
+		}
+
+		// liblxc has no hook once the container is started.
+		if v.key == "" {
+			t.unsupported(v.field)
+			continue
+		}
+
+		if !versioned {
+			// Version 1 passes the hook's details as environment
+			// variables instead of appending arguments.
+			t.set("lxc.hook.version", "1")
+			versioned = true
+		}
+
+		for i, hook := range v.hooks {
+			if hook.Timeout != nil {
+				t.unsupported(fmt.Sprintf("%s[%d].timeout", v.field, i))
+			}
+
+			t.set(v.key, hookCommand(hook))
+		}
+	}
+
+	return nil
+}
+
+// shellQuote quotes s for /bin/sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// hookCommand returns the shell command running hook. args[0] can't be set
+// through the shell and is dropped.
+func hookCommand(hook specs.Hook) string {
+	var words []string
+
+	if len(hook.Env) > 0 {
+		words = append(words, "env")
+		for _, env := range hook.Env {
+			words = append(words, shellQuote(env))
+		}
+	}
+
+	words = append(words, shellQuote(hook.Path))
+	if len(hook.Args) > 1 {
+		for _, arg := range hook.Args[1:] {
+			words = append(words, shellQuote(arg))
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// ociNamespaces maps OCI namespace types to the names liblxc uses.
+var ociNamespaces = map[specs.LinuxNamespaceType]string{
+	specs.PIDNamespace:     "pid",
+	specs.NetworkNamespace: "net",
+	specs.MountNamespace:   "mnt",
+	specs.IPCNamespace:     "ipc",
+	specs.UTSNamespace:     "uts",
+	specs.UserNamespace:    "user",
+	specs.CgroupNamespace:  "cgroup",
+	specs.TimeNamespace:    "time",
+}
+
+func (t *ociTranslator) linux(spec *specs.Spec) error {
+	l := spec.Linux
+	if l == nil {
+		return nil
+	}
+
+	for field, set := range map[string]bool{
+		"linux.mountLabel": l.MountLabel != "",
+		"linux.intelRdt":   l.IntelRdt != nil,
+	} {
+		if set {
+			t.unsupported(field)
+		}
+	}
+
+	for _, mapping := range []struct {
+		kind     string
+		mappings []specs.LinuxIDMapping
+	}{
+		{"u", l.UIDMappings},
+		{"g", l.GIDMappings},
+	} {
+		for _, m := range mapping.mappings {
+			t.set("lxc.idmap", fmt.Sprintf("%s %d %d %d", mapping.kind, m.ContainerID, m.HostID, m.Size))
+		}
+	}
+
+	// Namespaces which aren't listed are shared with the runtime, which
+	// is what leaving them out of lxc.namespace.clone does.
+	if len(l.Namespaces) > 0 {
+		var clone []string
+		for i, ns := range l.Namespaces {
+			name, ok := ociNamespaces[ns.Type]
+			if !ok {
+				t.unsupported(fmt.Sprintf("linux.namespaces[%d]", i))
+				continue
+			}
+
+			if ns.Path != "" {
+				t.set("lxc.namespace.share."+name, ns.Path)
+				continue
+			}
+			clone = append(clone, name)
+		}
+
+		if len(clone) > 0 {
+			t.set("lxc.namespace.clone", strings.Join(clone, " "))
+		}
+	}
+
+	keys := make([]string, 0, len(l.Sysctl))
+	for key := range l.Sysctl {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		t.set("lxc.sysctl."+key, l.Sysctl[key])
+	}
+
+	if l.CgroupsPath != "" {
+		// systemd style slice:prefix:name paths need a systemd driver.
+		if strings.Contains(l.CgroupsPath, ":") {
+			t.unsupported("linux.cgroupsPath")
+		} else {
+			t.set("lxc.cgroup.dir", strings.TrimPrefix(l.CgroupsPath, "/"))
+		}
+	}
+
+	for i, device := range l.Devices {
+		for field, set := range map[string]bool{
+			"fileMode": device.FileMode != nil,
+			"uid":      device.UID != nil,
+			"gid":      device.GID != nil,
+		} {
+			if set {
+				t.unsupported(fmt.Sprintf("linux.devices[%d].%s", i, field))
+			}
+		}
+
+		// Device nodes are bind mounted from the host.
+		t.mountEntry(device.Path, device.Path, "none", []string{"bind", "create=file", "optional"})
+	}
+
+	for _, path := range l.MaskedPaths {
+		resolved, err := secureJoin(t.rootfs, path)
+		if err != nil {
+			return err
+		}
+
+		// Most masked paths only exist once /proc and /sys are mounted, an
+		// entry is then set for either kind and the one not matching is
+		// skipped on start.
+		dir, file := true, true
+		if info, err := os.Stat(resolved); err == nil {
+			dir, file = info.IsDir(), !info.IsDir()
+		}
+
+		if dir {
+			t.mountEntry("tmpfs", path, "tmpfs", []string{"ro", "optional"})
+		}
+		if file {
+			t.mountEntry("/dev/null", path, "none", []string{"bind", "optional"})
+		}
+	}
+
+	// The path is bind mounted onto itself within the container's root,
+	// liblxc then remounts it read-only.
+	for _, path := range l.ReadonlyPaths {
+		source := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+		t.mountEntry(source, path, "none", []string{"bind", "ro", "relative", "optional"})
+	}
+
+	if l.Personality != nil {
+		switch l.Personality.Domain {
+		case specs.PerLinux:
+			t.set("lxc.arch", "linux64")
+		case specs.PerLinux32:
+			t.set("lxc.arch", "linux32")
+		default:
+			t.unsupported("linux.personality.domain")
+		}
+
+		if len(l.Personality.Flags) > 0 {
+			t.unsupported("linux.personality.flags")
+		}
+	}
+
+	clocks := make([]string, 0, len(l.TimeOffsets))
+	for clock := range l.TimeOffsets {
+		clocks = append(clocks, clock)
+	}
+	sort.Strings(clocks)
+	for _, clock := range clocks {
+		offset := l.TimeOffsets[clock]
+		value := fmt.Sprintf("%dns", time.Duration(offset.Secs)*time.Second+time.Duration(offset.Nanosecs))
+
+		switch clock {
+		case "monotonic":
+			t.set("lxc.time.offset.monotonic", value)
+		case "boottime":
+			t.set("lxc.time.offset.boot", value)
+		default:
+			t.unsupported("linux.timeOffsets." + clock)
+		}
+	}
+
+	if l.Resources != nil {
+		t.resources(l.Resources)
+	}
+
+	if l.Seccomp != nil {
+		profile, err := t.seccomp(l.Seccomp)
+		if err != nil {
+			return err
+		}
+		t.config.SeccompProfile = profile
+	}
+
+	return nil
+}
+
+// cgroup sets a cgroup key of the host's hierarchy.
+func (t *ociTranslator) cgroup(key string, value string) {
+	prefix := "lxc.cgroup"
+	if t.unified {
+		prefix = "lxc.cgroup2"
+	}
+	t.set(prefix+"."+key, value)
+}
+
+// cgroupLimit formats a byte limit, negative values meaning no limit.
+func (t *ociTranslator) cgroupLimit(limit int64) string {
+	if limit < 0 {
+		if t.unified {
+			return "max"
+		}
+		return "-1"
+	}
+	return strconv.FormatInt(limit, 10)
+}
+
+// blkioWeightToIOWeight converts cgroup v1 blkio.weight [10-1000] to cgroup v2 io.weight [1-10000].
+func blkioWeightToIOWeight(weight uint16) uint64 {
+	if weight < 10 {
+		weight = 10
+	}
+	return 1 + (uint64(weight)-10)*9999/990
+}
+
+func (t *ociTranslator) resources(r *specs.LinuxResources) {
+	for _, device := range r.Devices {
+		rule := DeviceRule{Type: AllDevices, Major: AnyDevice, Minor: AnyDevice, Access: device.Access, Allow: device.Allow}
+		switch device.Type {
+		case "c":
+			rule.Type = CharDevice
+		case "b":
+			rule.Type = BlockDevice
+		}
+		if device.Major != nil {
+			rule.Major = *device.Major
+		}
+		if device.Minor != nil {
+			rule.Minor = *device.Minor
+		}
+		if rule.Access == "" {
+			rule.Access = "rwm"
+		}
+
+		key := "devices.deny"
+		if rule.Allow {
+			key = "devices.allow"
+		}
+		t.cgroup(key, rule.String())
+	}
+
+	if m := r.Memory; m != nil {
+		t.memory(m)
+	}
+
+	if cpu := r.CPU; cpu != nil {
+		limits := CPULimits{Cpus: cpu.Cpus, Mems: cpu.Mems}
+		if cpu.Shares != nil {
+			if t.unified {
+				limits.Weight = cpuSharesToWeight(*cpu.Shares)
+			} else {
+				// Converting to a weight and back would lose precision.
+				t.cgroup("cpu.shares", strconv.FormatUint(*cpu.Shares, 10))
+			}
+		}
+		if cpu.Quota != nil {
+			limits.Quota = time.Duration(*cpu.Quota) * time.Microsecond
+		}
+		if cpu.Period != nil {
+			limits.Period = time.Duration(*cpu.Period) * time.Microsecond
+		}
+
+		for _, setting := range limits.settings(t.unified, "") {
+			t.cgroup(setting.key, setting.value)
+		}
+
+		if cpu.Burst != nil {
+			if t.unified {
+				t.cgroup("cpu.max.burst", strconv.FormatUint(*cpu.Burst, 10))
+			} else {
+				t.cgroup("cpu.cfs_burst_us", strconv.FormatUint(*cpu.Burst, 10))
+			}
+		}
+
+		if cpu.Idle != nil {
+			if t.unified {
+				t.cgroup("cpu.idle", strconv.FormatInt(*cpu.Idle, 10))
+			} else {
+				t.unsupported("linux.resources.cpu.idle")
+			}
+		}
+
+		if cpu.RealtimeRuntime != nil || cpu.RealtimePeriod != nil {
+			t.unsupported("linux.resources.cpu.realtimeRuntime")
+		}
+	}
+
+	if r.Pids != nil {
+		limit := "max"
+		if r.Pids.Limit > 0 {
+			limit = strconv.FormatInt(r.Pids.Limit, 10)
+		}
+		t.cgroup("pids.max", limit)
+	}
+
+	if r.BlockIO != nil {
+		t.blockIO(r.BlockIO)
+	}
+
+	for _, hugepage := range r.HugepageLimits {
+		if t.unified {
+			t.cgroup(fmt.Sprintf("hugetlb.%s.max", hugepage.Pagesize), strconv.FormatUint(hugepage.Limit, 10))
+		} else {
+			t.cgroup(fmt.Sprintf("hugetlb.%s.limit_in_bytes", hugepage.Pagesize), strconv.FormatUint(hugepage.Limit, 10))
+		}
+	}
+
+	if r.Network != nil {
+		t.unsupported("linux.resources.network")
+	}
+
+	if len(r.Rdma) > 0 {
+		t.unsupported("linux.resources.rdma")
+	}
+
+	if len(r.Unified) > 0 {
+		if !t.unified {
+			t.unsupported("linux.resources.unified")
+		} else {
+			keys := make([]string, 0, len(r.Unified))
+			for key := range r.Unified {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				t.cgroup(key, r.Unified[key])
+			}
+		}
+	}
+}
+
+func (t *ociTranslator) memory(m *specs.LinuxMemory) {
+	for field, set := range map[string]bool{
+		"linux.resources.memory.kernel":       m.Kernel != nil,
+		"linux.resources.memory.kernelTCP":    m.KernelTCP != nil,
+		"linux.resources.memory.useHierarchy": m.UseHierarchy != nil,
+	} {
+		if set {
+			t.unsupported(field)
+		}
+	}
+
+	if t.unified {
+		if m.Limit != nil {
+			t.cgroup("memory.max", t.cgroupLimit(*m.Limit))
+		}
+		if m.Reservation != nil {
+			t.cgroup("memory.low", t.cgroupLimit(*m.Reservation))
+		}
+		// The spec's swap limit includes memory, memory.swap.max doesn't.
+		if m.Swap != nil {
+			swap := *m.Swap
+			if swap > 0 && m.Limit != nil && *m.Limit > 0 {
+				swap -= *m.Limit
+			}
+			t.cgroup("memory.swap.max", t.cgroupLimit(swap))
+		}
+		if m.Swappiness != nil {
+			t.unsupported("linux.resources.memory.swappiness")
+		}
+		if m.DisableOOMKiller != nil && *m.DisableOOMKiller {
+			t.unsupported("linux.resources.memory.disableOOMKiller")
+		}
+		return
+	}
+
+	if m.Limit != nil {
+		t.cgroup("memory.limit_in_bytes", t.cgroupLimit(*m.Limit))
+	}
+	if m.Reservation != nil {
+		t.cgroup("memory.soft_limit_in_bytes", t.cgroupLimit(*m.Reservation))
+	}
+	if m.Swap != nil {
+		t.cgroup("memory.memsw.limit_in_bytes", t.cgroupLimit(*m.Swap))
+	}
+	if m.Swappiness != nil {
+		t.cgroup("memory.swappiness", strconv.FormatUint(*m.Swappiness, 10))
+	}
+	if m.DisableOOMKiller != nil && *m.DisableOOMKiller {
+		t.cgroup("memory.oom_control", "1")
+	}
+}
+
+func (t *ociTranslator) blockIO(b *specs.LinuxBlockIO) {
+	if b.LeafWeight != nil {
+		t.unsupported("linux.resources.blockIO.leafWeight")
+	}
+
+	if b.Weight != nil {
+		if t.unified {
+			t.cgroup("io.weight", strconv.FormatUint(blkioWeightToIOWeight(*b.Weight), 10))
+		} else {
+			t.cgroup("blkio.weight", strconv.FormatUint(uint64(*b.Weight), 10))
+		}
+	}
+
+	for i, device := range b.WeightDevice {
+		if device.LeafWeight != nil {
+			t.unsupported(fmt.Sprintf("linux.resources.blockIO.weightDevice[%d].leafWeight", i))
+		}
+
+		if device.Weight == nil {
+			continue
+		}
+
+		if t.unified {
+			t.cgroup("io.weight", fmt.Sprintf("%d:%d %d", device.Major, device.Minor, blkioWeightToIOWeight(*device.Weight)))
+		} else {
+			t.cgroup("blkio.weight_device", fmt.Sprintf("%d:%d %d", device.Major, device.Minor, *device.Weight))
+		}
+	}
+
+	// Merge the throttling of each device into a single limit.
+	var devices []string
+	limits := make(map[string]*IOLimit)
+	for i, throttle := range [][]specs.LinuxThrottleDevice{
+		b.ThrottleReadBpsDevice,
+		b.ThrottleWriteBpsDevice,
+		b.ThrottleReadIOPSDevice,
+		b.ThrottleWriteIOPSDevice,
+	} {
+		for _, device := range throttle {
+			number := fmt.Sprintf("%d:%d", device.Major, device.Minor)
+
+			limit, ok := limits[number]
+			if !ok {
+				limit = &IOLimit{Device: number}
+				limits[number] = limit
+				devices = append(devices, number)
+			}
+
+			switch i {
+			case 0:
+				limit.ReadBPS = ByteSize(device.Rate)
+			case 1:
+				limit.WriteBPS = ByteSize(device.Rate)
+			case 2:
+				limit.ReadIOPS = device.Rate
+			case 3:
+				limit.WriteIOPS = device.Rate
+			}
+		}
+	}
+
+	ioLimits := make([]IOLimit, 0, len(devices))
+	for _, device := range devices {
+		ioLimits = append(ioLimits, *limits[device])
+	}
+
+	for _, setting := range ioLimitSettings(ioLimits, t.unified) {
+		t.cgroup(setting.key, setting.value)
+	}
+}
+
+// seccompAction translates a seccomp action to the LXC policy syntax.
+func seccompAction(action specs.LinuxSeccompAction, errnoRet *uint, defaultErrnoRet *uint) (string, bool) {
+	switch action {
+	case specs.ActAllow:
+		return "allow", true
+	case specs.ActKill, specs.ActKillThread, specs.ActKillProcess:
+		return "kill", true
+	case specs.ActTrap:
+		return "trap", true
+	case specs.ActLog:
+		return "log", true
+	case specs.ActNotify:
+		return "notify", true
+	case specs.ActErrno:
+		errno := uint(1)
+		if errnoRet != nil {
+			errno = *errnoRet
+		} else if defaultErrnoRet != nil {
+			errno = *defaultErrnoRet
+		}
+		return fmt.Sprintf("errno %d", errno), true
+	}
+	return "", false
+}
+
+// seccomp translates the seccomp configuration to a version 2 LXC policy.
+func (t *ociTranslator) seccomp(s *specs.LinuxSeccomp) (string, error) {
+	if len(s.Flags) > 0 {
+		t.unsupported("linux.seccomp.flags")
+	}
+
+	if s.ListenerPath != "" || s.ListenerMetadata != "" {
+		t.unsupported("linux.seccomp.listenerPath")
+	}
+
+	// liblxc picks the architectures of the filter itself, the native one
+	// and its compat ones.
+	if len(s.Architectures) > 0 {
+		t.unsupported("linux.seccomp.architectures")
+	}
+
+	defaultAction, ok := seccompAction(s.DefaultAction, s.DefaultErrnoRet, nil)
+	if !ok {
+		return "", fmt.Errorf("%s: unsupported seccomp default action %q", ErrInvalidOCISpec, s.DefaultAction)
+	}
+
+	lines := []string{"2"}
+	if s.DefaultAction == specs.ActAllow {
+		lines = append(lines, "denylist")
+	} else {
+		lines = append(lines, "allowlist "+defaultAction)
+	}
+
+	for i, syscall := range s.Syscalls {
+		action, ok := seccompAction(syscall.Action, syscall.ErrnoRet, s.DefaultErrnoRet)
+		if !ok {
+			t.unsupported(fmt.Sprintf("linux.seccomp.syscalls[%d].action", i))
+			continue
+		}
+
+		var args []string
+		for _, arg := range syscall.Args {
+			args = append(args, fmt.Sprintf("[%d,%d,%s,%d]", arg.Index, arg.Value, arg.Op, arg.ValueTwo))
+		}
+
+		for _, name := range syscall.Names {
+			lines = append(lines, strings.Join(append([]string{name, action}, args...), " "))
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
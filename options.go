@@ -9,6 +9,7 @@ package lxc
 
 import (
 	"os"
+	"time"
 )
 
 // AttachOptions type is used for defining various attach options.
@@ -124,6 +125,27 @@ type TemplateOptions struct {
 	ExtraArgs []string
 }
 
+// RootfsSource type is used for defining the local root filesystem a
+// container is created from. Exactly one of its fields has to be set.
+type RootfsSource struct {
+	// Tarball is a tar archive of the root filesystem, optionally
+	// compressed with gzip, xz or zstd.
+	Tarball string
+
+	// Dir is a directory holding the root filesystem.
+	Dir string
+}
+
+// OCIOptions type is used for defining how a container is created from an
+// OCI image.
+type OCIOptions struct {
+
+	// Backend specifies the type of the backend.
+	Backend BackendStore
+
+	BackendSpecs *BackendStoreSpecs
+}
+
 // BackendStoreSpecs represents a LXC storage backend.
 type BackendStoreSpecs struct {
 	FSType string
@@ -203,6 +225,22 @@ type CloneOptions struct {
 
 	// Create a snapshot rather than copy.
 	Snapshot bool
+
+	// Create a snapshot if the backend supports it, copy otherwise.
+	MaybeSnapshot bool
+
+	// Use the same backend as the original container, ignoring Backend.
+	KeepBdevType bool
+
+	// BackendSpecs of the new storage. liblxc only accepts FSType and FSSize
+	// when cloning, the other settings come from LXC's global configuration.
+	BackendSpecs *BackendStoreSpecs
+
+	// NewSize of block device backed storage, the size of the original is used if not set.
+	NewSize ByteSize
+
+	// HookArgs are passed to the clone hook scripts.
+	HookArgs []string
 }
 
 // DefaultCloneOptions is a convenient set of options to be used.
@@ -210,6 +248,80 @@ var DefaultCloneOptions = CloneOptions{
 	Backend: Directory,
 }
 
+// SnapshotOptions type is used for defining snapshot options.
+type SnapshotOptions struct {
+
+	// Comment is stored along with the snapshot.
+	Comment string
+}
+
+// ExportOptions type is used for defining container export options.
+type ExportOptions struct {
+
+	// Compression of the archive.
+	Compression Compression
+
+	// IncludeSnapshots also exports the container's snapshots.
+	IncludeSnapshots bool
+}
+
+// ImportOptions type is used for defining container import options.
+type ImportOptions struct {
+	// Backend the root filesystem is restored on (default: Directory).
+	Backend BackendStore
+
+	BackendSpecs *BackendStoreSpecs
+}
+
+// FileOptions type is used for defining the ownership and permissions of
+// files created in a container. UID and GID are the IDs inside the container.
+type FileOptions struct {
+	UID  int
+	GID  int
+	Mode os.FileMode
+}
+
+// MountOptions type is used for defining how a mount is injected into a
+// running container.
+type MountOptions struct {
+	// FSType is the type of the filesystem to mount, source is bind
+	// mounted if it is empty.
+	FSType string
+
+	// Flags are the mount(2) flags, e.g. unix.MS_REC or unix.MS_NOSUID.
+	Flags uintptr
+
+	// Data holds the filesystem specific mount options.
+	Data string
+
+	ReadOnly bool
+}
+
+// LimitOptions type is used for defining how resource limits are applied.
+type LimitOptions struct {
+
+	// Persist also stores the limits in the container's configuration file so
+	// they are applied on the next start. Limits of a stopped container can
+	// only be persisted.
+	Persist bool
+}
+
+// SamplerOptions type is used for defining how resource usage is sampled.
+type SamplerOptions struct {
+
+	// Interval between two samples.
+	Interval time.Duration
+
+	// History is the number of samples kept per container, 0 to keep none.
+	History int
+}
+
+// DefaultSamplerOptions is a convenient set of options to be used.
+var DefaultSamplerOptions = SamplerOptions{
+	Interval: time.Second,
+	History:  60,
+}
+
 // CheckpointOptions type is used for defining checkpoint options for CRIU.
 type CheckpointOptions struct {
 	Directory string
@@ -0,0 +1,261 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// PressureResource type specifies the resources pressure stall information is available for.
+type PressureResource int
+
+const (
+	// CPUPressure is the pressure on the CPU
+	CPUPressure PressureResource = iota + 1
+	// MemoryPressure is the pressure on memory
+	MemoryPressure
+	// IOPressure is the pressure on block I/O
+	IOPressure
+)
+
+// PressureResource as string
+func (r PressureResource) String() string {
+	switch r {
+	case CPUPressure:
+		return "cpu"
+	case MemoryPressure:
+		return "memory"
+	case IOPressure:
+		return "io"
+	}
+	return ""
+}
+
+// Pressure represents the pressure stall information (PSI) of a container.
+type Pressure struct {
+	CPU    PressureStats
+	Memory PressureStats
+	IO     PressureStats
+}
+
+// PressureStats represents the stall times of a single resource.
+// Some is the share of time at least one task was stalled and Full the share
+// of time all non-idle tasks were stalled at the same time.
+type PressureStats struct {
+	Some PressureAverages
+	Full PressureAverages
+}
+
+// PressureAverages represents the stall time percentages over the last 10, 60
+// and 300 seconds along with the total stall time.
+type PressureAverages struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  time.Duration
+}
+
+// PressureTrigger describes a threshold on the pressure stall information of
+// a container. It fires when tasks were stalled for at least Threshold during
+// Window, which the kernel requires to be between 500ms and 10s.
+type PressureTrigger struct {
+	Resource PressureResource
+
+	// Full watches for all non-idle tasks being stalled instead of at least one.
+	Full bool
+
+	Threshold time.Duration
+	Window    time.Duration
+}
+
+// PressureEvent is delivered when a PressureTrigger fires.
+type PressureEvent struct {
+	Trigger PressureTrigger
+	Time    time.Time
+}
+
+func (t PressureTrigger) String() string {
+	kind := "some"
+	if t.Full {
+		kind = "full"
+	}
+	return fmt.Sprintf("%s %d %d", kind, t.Threshold.Microseconds(), t.Window.Microseconds())
+}
+
+func (t PressureTrigger) validate() error {
+	if t.Resource.String() == "" {
+		return fmt.Errorf("invalid pressure resource %d", t.Resource)
+	}
+
+	if t.Window < 500*time.Millisecond || t.Window > 10*time.Second {
+		return fmt.Errorf("pressure window %s is not between 500ms and 10s", t.Window)
+	}
+
+	if t.Threshold <= 0 || t.Threshold > t.Window {
+		return fmt.Errorf("pressure threshold %s is not within the window %s", t.Threshold, t.Window)
+	}
+
+	return nil
+}
+
+// Pressure returns the pressure stall information of the container.
+// It requires the unified (v2) cgroup hierarchy and a kernel with PSI enabled.
+func (c *Container) Pressure() (*Pressure, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	path, err := c.cgroupPath("")
+	if err != nil {
+		return nil, err
+	}
+
+	pressure := &Pressure{}
+	for _, v := range []struct {
+		resource PressureResource
+		stats    *PressureStats
+	}{
+		{CPUPressure, &pressure.CPU},
+		{MemoryPressure, &pressure.Memory},
+		{IOPressure, &pressure.IO},
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(path, v.resource.String()+".pressure"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, ErrPressureUnavailable
+			}
+			return nil, err
+		}
+
+		if *v.stats, err = parsePressure(strings.Split(string(content), "\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	return pressure, nil
+}
+
+// parsePressure parses the content of a <resource>.pressure file.
+func parsePressure(lines []string) (PressureStats, error) {
+	var stats PressureStats
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var averages *PressureAverages
+		switch fields[0] {
+		case "some":
+			averages = &stats.Some
+		case "full":
+			averages = &stats.Full
+		default:
+			return stats, fmt.Errorf("malformed pressure line %q", line)
+		}
+
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return stats, fmt.Errorf("malformed pressure line %q", line)
+			}
+
+			if kv[0] == "total" {
+				total, err := strconv.ParseUint(kv[1], 10, 64)
+				if err != nil {
+					return stats, err
+				}
+				averages.Total = time.Duration(total) * time.Microsecond
+				continue
+			}
+
+			value, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return stats, err
+			}
+
+			switch kv[0] {
+			case "avg10":
+				averages.Avg10 = value
+			case "avg60":
+				averages.Avg60 = value
+			case "avg300":
+				averages.Avg300 = value
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// PressureNotify registers the given trigger on the container's cgroup and
+// delivers an event on the returned channel every time it fires. The channel
+// is closed once the context is done or the container's cgroup goes away.
+func (c *Container) PressureNotify(ctx context.Context, trigger PressureTrigger) (<-chan PressureEvent, error) {
+	if err := trigger.validate(); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	path, err := c.cgroupPath("")
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := unix.Open(filepath.Join(path, trigger.Resource.String()+".pressure"), unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if err == unix.ENOENT {
+			return nil, ErrPressureUnavailable
+		}
+		return nil, err
+	}
+
+	// The trigger stays registered for as long as the file stays open.
+	if _, err := unix.Write(fd, append([]byte(trigger.String()), 0)); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	ch := make(chan PressureEvent)
+
+	err = pollFd(ctx, fd, unix.POLLPRI, func() bool {
+		select {
+		case ch <- PressureEvent{Trigger: trigger, Time: time.Now()}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() {
+		close(ch)
+	})
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return ch, nil
+}
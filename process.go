@@ -0,0 +1,414 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Process represents a task running in a container.
+type Process struct {
+	// Pid is the process ID seen from the host and NSPid the one seen from
+	// inside the container's PID namespace.
+	Pid   int
+	NSPid int
+	PPid  int
+
+	// Command is the name of the executable and Cmdline the full command
+	// line, empty for kernel threads and zombies.
+	Command string
+	Cmdline []string
+
+	// UID is the real user ID inside the container, -1 if it isn't mapped
+	// into the container, and HostUID the one seen from the host.
+	UID     int
+	HostUID int
+
+	// User is the name of UID in the container's /etc/passwd, empty if it
+	// has none.
+	User string
+
+	// State is the single letter state as shown by ps (e.g. "R" or "S").
+	State string
+
+	RSS     ByteSize
+	CPUTime time.Duration
+}
+
+// Processes returns the processes running in the container, sorted by Pid.
+func (c *Container) Processes() ([]Process, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	path, err := c.tasksCgroupPath()
+	if err != nil {
+		return nil, err
+	}
+
+	pids, err := cgroupProcs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := c.idmap()
+
+	// The names are best effort, the container may not have any.
+	users, _ := c.containerUsers()
+
+	processes := make([]Process, 0, len(pids))
+	for _, pid := range pids {
+		process, err := readProcess(pid)
+		if err != nil {
+			// The process exited in the meantime.
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		process.UID = ids.containerUID(process.HostUID)
+		process.User = users[process.UID]
+		processes = append(processes, *process)
+	}
+
+	return processes, nil
+}
+
+// tasksCgroupPath returns the path of a cgroup listing the container's tasks.
+// On cgroup v1 any controller does, the first one mounted on the host is used.
+//
+// Caller needs to hold the lock
+func (c *Container) tasksCgroupPath() (string, error) {
+	if cgroup2() {
+		return c.cgroupPath("")
+	}
+
+	for _, controller := range []string{"pids", "cpuacct", "memory", "cpu", "freezer", "devices", "blkio"} {
+		path, err := c.cgroupPath(controller)
+		if err != nil {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(path, "cgroup.procs")); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", ErrCgroupNotFound
+}
+
+// containerUsers returns the user names of the container's /etc/passwd keyed
+// by UID.
+//
+// Caller needs to hold the lock
+func (c *Container) containerUsers() (map[int]string, error) {
+	root, err := unix.Open(fmt.Sprintf("/proc/%d/root", c.initPid()), unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(root)
+
+	// Symlinks are resolved within the container's root.
+	fd, err := unix.Openat2(root, "etc/passwd", &unix.OpenHow{
+		Flags:   unix.O_RDONLY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "/etc/passwd")
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePasswd(strings.Split(string(content), "\n")), nil
+}
+
+// parsePasswd parses the content of /etc/passwd into the user names keyed by
+// UID, keeping the first name of a UID.
+func parsePasswd(lines []string) map[int]string {
+	users := make(map[int]string)
+
+	for _, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+
+		if _, ok := users[uid]; !ok {
+			users[uid] = fields[0]
+		}
+	}
+
+	return users
+}
+
+// cgroupProcs returns the processes of the cgroup and all of its descendants,
+// as init systems like systemd move the tasks into child cgroups.
+func cgroupProcs(path string) ([]int, error) {
+	var pids []int
+
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Child cgroups may be removed while walking.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() || info.Name() != "cgroup.procs" {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		for _, line := range strings.Fields(string(content)) {
+			pid, err := strconv.Atoi(line)
+			if err != nil {
+				return err
+			}
+			pids = append(pids, pid)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Ints(pids)
+
+	return pids, nil
+}
+
+func readProcess(pid int) (*Process, error) {
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	process, err := parseProcStatus(strings.Split(string(status), "\n"))
+	if err != nil {
+		return nil, err
+	}
+	process.Pid = pid
+
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	if process.CPUTime, err = parseProcStatCPUTime(string(stat)); err != nil {
+		return nil, err
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, arg := range bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0}) {
+		if len(arg) > 0 {
+			process.Cmdline = append(process.Cmdline, string(arg))
+		}
+	}
+
+	return process, nil
+}
+
+// parseProcStatus parses the fields of /proc/<pid>/status used by Process.
+func parseProcStatus(lines []string) (*Process, error) {
+	process := &Process{UID: -1, HostUID: -1}
+
+	for _, line := range lines {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		fields := strings.Fields(kv[1])
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch kv[0] {
+		case "Name":
+			process.Command = strings.TrimSpace(kv[1])
+		case "State":
+			process.State = fields[0]
+		case "PPid":
+			process.PPid, err = strconv.Atoi(fields[0])
+		case "Uid":
+			process.HostUID, err = strconv.Atoi(fields[0])
+		case "NSpid":
+			// The innermost namespace comes last.
+			process.NSPid, err = strconv.Atoi(fields[len(fields)-1])
+		case "VmRSS":
+			var rss uint64
+			rss, err = strconv.ParseUint(fields[0], 10, 64)
+			process.RSS = ByteSize(rss) * KB
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed process status line %q", line)
+		}
+	}
+
+	return process, nil
+}
+
+// parseProcStatCPUTime returns the user and system time from /proc/<pid>/stat.
+func parseProcStatCPUTime(stat string) (time.Duration, error) {
+	// The command name may contain spaces and parentheses.
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return 0, fmt.Errorf("malformed process stat %q", stat)
+	}
+
+	// utime and stime are the 14th and 15th fields, the fields following the
+	// command name start with the 3rd one.
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("malformed process stat %q", stat)
+	}
+
+	var ticks uint64
+	for _, field := range fields[11:13] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ticks += value
+	}
+
+	return time.Duration(ticks) * time.Second / userHZ, nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) idmap() idmap {
+	key := "lxc.idmap"
+	if !VersionAtLeast(2, 1, 0) {
+		key = "lxc.id_map"
+	}
+
+	return parseIDMap(c.configItem(key))
+}
+
+// idmapEntry is a single "u|g|b nsid hostid range" entry of lxc.idmap.
+type idmapEntry struct {
+	kind   string
+	nsid   int
+	hostid int
+	count  int
+}
+
+type idmap []idmapEntry
+
+// parseIDMap parses the lxc.idmap entries, skipping malformed ones.
+func parseIDMap(entries []string) idmap {
+	var m idmap
+
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) != 4 {
+			continue
+		}
+
+		values := make([]int, 3)
+		valid := true
+		for i, field := range fields[1:] {
+			value, err := strconv.Atoi(field)
+			if err != nil {
+				valid = false
+				break
+			}
+			values[i] = value
+		}
+		if !valid {
+			continue
+		}
+
+		m = append(m, idmapEntry{kind: fields[0], nsid: values[0], hostid: values[1], count: values[2]})
+	}
+
+	return m
+}
+
+// containerUID maps a host UID into the container.
+func (m idmap) containerUID(uid int) int {
+	return m.toContainer("u", uid)
+}
+
+// toContainer maps a host ID of the given kind ("u" or "g") into the
+// container, -1 if it isn't mapped. Without any idmap the container is
+// privileged and IDs are the same on both sides.
+func (m idmap) toContainer(kind string, id int) int {
+	if len(m) == 0 || id < 0 {
+		return id
+	}
+
+	for _, entry := range m {
+		if entry.kind != kind && entry.kind != "b" {
+			continue
+		}
+
+		if id >= entry.hostid && id < entry.hostid+entry.count {
+			return entry.nsid + id - entry.hostid
+		}
+	}
+
+	return -1
+}
+
+// toHost maps an ID of the given kind ("u" or "g") in the container to the
+// host, -1 if it isn't mapped.
+func (m idmap) toHost(kind string, id int) int {
+	if len(m) == 0 || id < 0 {
+		return id
+	}
+
+	for _, entry := range m {
+		if entry.kind != kind && entry.kind != "b" {
+			continue
+		}
+
+		if id >= entry.nsid && id < entry.nsid+entry.count {
+			return entry.hostid + id - entry.nsid
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,205 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+// Package prometheus provides a Prometheus collector exporting the state and
+// the resource usage of LXC containers.
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/lxc/go-lxc"
+)
+
+const namespace = "lxc_container"
+
+var (
+	labels = []string{"name", "lxcpath"}
+
+	stateDesc = newDesc("state", "Current state of the container, 1 for the state it is in.", "state")
+
+	memoryUsageDesc = newDesc("memory_usage_bytes", "Memory usage of the container in bytes.")
+	memoryLimitDesc = newDesc("memory_limit_bytes", "Memory limit of the container in bytes.")
+	memoryCacheDesc = newDesc("memory_cache_bytes", "Page cache used by the container in bytes.")
+	memoryRSSDesc   = newDesc("memory_rss_bytes", "Anonymous memory used by the container in bytes.")
+	swapUsageDesc   = newDesc("swap_usage_bytes", "Swap usage of the container in bytes.")
+	swapLimitDesc   = newDesc("swap_limit_bytes", "Swap limit of the container in bytes.")
+
+	cpuUsageDesc            = newDesc("cpu_usage_seconds_total", "Total CPU time consumed by the container.")
+	cpuDesc                 = newDesc("cpu_seconds_total", "CPU time consumed by the container per mode.", "mode")
+	cpuPeriodsDesc          = newDesc("cpu_periods_total", "Number of elapsed CFS enforcement periods.")
+	cpuThrottledPeriodsDesc = newDesc("cpu_throttled_periods_total", "Number of CFS periods the container was throttled in.")
+	cpuThrottledTimeDesc    = newDesc("cpu_throttled_seconds_total", "Total time the container was throttled for.")
+
+	blkioReadBytesDesc  = newDesc("blkio_read_bytes_total", "Bytes read from the device by the container.", "device")
+	blkioWriteBytesDesc = newDesc("blkio_write_bytes_total", "Bytes written to the device by the container.", "device")
+	blkioReadOpsDesc    = newDesc("blkio_read_ops_total", "Read operations issued to the device by the container.", "device")
+	blkioWriteOpsDesc   = newDesc("blkio_write_ops_total", "Write operations issued to the device by the container.", "device")
+
+	networkReceiveDesc  = newDesc("network_receive_bytes_total", "Bytes received by the container on the interface.", "interface")
+	networkTransmitDesc = newDesc("network_transmit_bytes_total", "Bytes transmitted by the container on the interface.", "interface")
+
+	pidsDesc      = newDesc("pids", "Number of tasks in the container.")
+	pidsLimitDesc = newDesc("pids_limit", "Maximum number of tasks in the container.")
+
+	statsErrorDesc = newDesc("stats_error", "Whether reading the statistics of the subsystem failed, its metrics are then missing.", "subsystem")
+)
+
+// subsystems are the keys of lxc.StatsError.Failed.
+var subsystems = []string{"memory", "cpu", "blkio", "pids", "network"}
+
+func newDesc(name string, help string, extraLabels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, append(labels, extraLabels...), nil)
+}
+
+// Collector exports the metrics of all the containers of the given lxcpaths.
+// Containers that go away while being scraped are skipped. The subsystems
+// whose statistics can't be read are reported by lxc_container_stats_error
+// and their metrics left out.
+type Collector struct {
+	lxcpaths []string
+}
+
+// NewCollector returns a collector for the containers of the given lxcpaths,
+// the default lxcpath is used if none is given.
+func NewCollector(lxcpaths ...string) *Collector {
+	if len(lxcpaths) == 0 {
+		lxcpaths = []string{lxc.DefaultConfigPath()}
+	}
+
+	return &Collector{lxcpaths: lxcpaths}
+}
+
+// Describe is part of the prometheus.Collector interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		stateDesc,
+		memoryUsageDesc, memoryLimitDesc, memoryCacheDesc, memoryRSSDesc, swapUsageDesc, swapLimitDesc,
+		cpuUsageDesc, cpuDesc, cpuPeriodsDesc, cpuThrottledPeriodsDesc, cpuThrottledTimeDesc,
+		blkioReadBytesDesc, blkioWriteBytesDesc, blkioReadOpsDesc, blkioWriteOpsDesc,
+		networkReceiveDesc, networkTransmitDesc,
+		pidsDesc, pidsLimitDesc,
+		statsErrorDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect is part of the prometheus.Collector interface.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, lxcpath := range c.lxcpaths {
+		for _, name := range lxc.ContainerNames(lxcpath) {
+			c.collectContainer(ch, name, lxcpath)
+		}
+	}
+}
+
+func (c *Collector) collectContainer(ch chan<- prometheus.Metric, name string, lxcpath string) {
+	container, err := lxc.NewContainer(name, lxcpath)
+	if err != nil {
+		return
+	}
+	defer container.Release()
+
+	state := container.State()
+	if !container.Defined() && state == lxc.STOPPED {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(stateDesc, prometheus.GaugeValue, 1, name, lxcpath, state.String())
+
+	if state != lxc.RUNNING && state != lxc.FROZEN {
+		return
+	}
+
+	failed := make(map[string]error)
+
+	stats, err := container.Stats()
+	if err != nil {
+		var statsErr *lxc.StatsError
+		if errors.As(err, &statsErr) {
+			failed = statsErr.Failed
+		} else if !container.Running() {
+			// The container stopped in the meantime.
+			return
+		} else {
+			for _, subsystem := range subsystems {
+				failed[subsystem] = err
+			}
+		}
+	}
+
+	for _, subsystem := range subsystems {
+		value := 0.0
+		if failed[subsystem] != nil {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(statsErrorDesc, prometheus.GaugeValue, value, name, lxcpath, subsystem)
+	}
+
+	if stats == nil {
+		return
+	}
+
+	gauge := func(desc *prometheus.Desc, value float64, extra ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, append([]string{name, lxcpath}, extra...)...)
+	}
+
+	counter := func(desc *prometheus.Desc, value float64, extra ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, append([]string{name, lxcpath}, extra...)...)
+	}
+
+	if failed["memory"] == nil {
+		gauge(memoryUsageDesc, float64(stats.Memory.Usage))
+		if stats.Memory.Limit >= 0 {
+			gauge(memoryLimitDesc, float64(stats.Memory.Limit))
+		}
+		gauge(memoryCacheDesc, float64(stats.Memory.Cache))
+		gauge(memoryRSSDesc, float64(stats.Memory.RSS))
+		gauge(swapUsageDesc, float64(stats.Memory.SwapUsage))
+		if stats.Memory.SwapLimit >= 0 {
+			gauge(swapLimitDesc, float64(stats.Memory.SwapLimit))
+		}
+	}
+
+	if failed["cpu"] == nil {
+		counter(cpuUsageDesc, stats.CPU.Usage.Seconds())
+		counter(cpuDesc, stats.CPU.User.Seconds(), "user")
+		counter(cpuDesc, stats.CPU.System.Seconds(), "system")
+		counter(cpuPeriodsDesc, float64(stats.CPU.Throttling.Periods))
+		counter(cpuThrottledPeriodsDesc, float64(stats.CPU.Throttling.ThrottledPeriods))
+		counter(cpuThrottledTimeDesc, stats.CPU.Throttling.ThrottledTime.Seconds())
+	}
+
+	// Failed subsystems have no entries.
+	for _, device := range stats.BlockIO {
+		label := device.Name
+		if label == "" {
+			label = fmt.Sprintf("%d:%d", device.Major, device.Minor)
+		}
+
+		counter(blkioReadBytesDesc, float64(device.ReadBytes), label)
+		counter(blkioWriteBytesDesc, float64(device.WriteBytes), label)
+		counter(blkioReadOpsDesc, float64(device.ReadOps), label)
+		counter(blkioWriteOpsDesc, float64(device.WriteOps), label)
+	}
+
+	for iface, network := range stats.Network {
+		counter(networkReceiveDesc, float64(network.Rx.Bytes), iface)
+		counter(networkTransmitDesc, float64(network.Tx.Bytes), iface)
+	}
+
+	if failed["pids"] == nil {
+		gauge(pidsDesc, float64(stats.Pids.Current))
+		if stats.Pids.Limit >= 0 {
+			gauge(pidsLimitDesc, float64(stats.Pids.Limit))
+		}
+	}
+}
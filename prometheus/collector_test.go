@@ -0,0 +1,127 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/lxc/go-lxc"
+)
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector()
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if _, err := registry.Gather(); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if problems, err := testutil.CollectAndLint(collector); err != nil {
+		t.Errorf(err.Error())
+	} else {
+		for _, problem := range problems {
+			t.Errorf("%s: %s", problem.Metric, problem.Text)
+		}
+	}
+}
+
+func TestCollectorRunningContainer(t *testing.T) {
+	containers := lxc.ActiveContainers(lxc.DefaultConfigPath())
+	defer func() {
+		for _, c := range containers {
+			c.Release()
+		}
+	}()
+
+	var container *lxc.Container
+	for _, c := range containers {
+		if c.Running() {
+			container = c
+			break
+		}
+	}
+	if container == nil {
+		t.Skip("no running container to scrape")
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(NewCollector()); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// Values of the scraped container keyed by metric name and extra label.
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["name"] != container.Name() {
+				continue
+			}
+
+			key := family.GetName()
+			for _, extra := range []string{"state", "subsystem", "interface", "mode"} {
+				if value, ok := labels[extra]; ok {
+					key += "/" + value
+				}
+			}
+			values[key] = metricValue(metric)
+		}
+	}
+
+	if values["lxc_container_state/RUNNING"] != 1 {
+		t.Errorf("expected the container to be reported running, got %v", values)
+	}
+
+	for _, subsystem := range subsystems {
+		if value, ok := values["lxc_container_stats_error/"+subsystem]; !ok || value != 0 {
+			t.Errorf("expected no error reading %s statistics", subsystem)
+		}
+	}
+
+	if values["lxc_container_memory_usage_bytes"] <= 0 {
+		t.Errorf("expected a memory usage, got %v", values["lxc_container_memory_usage_bytes"])
+	}
+
+	if values["lxc_container_cpu_usage_seconds_total"] <= 0 {
+		t.Errorf("expected a CPU usage, got %v", values["lxc_container_cpu_usage_seconds_total"])
+	}
+
+	if pids, ok := values["lxc_container_pids"]; ok && pids < 1 {
+		t.Errorf("expected at least one task, got %v", pids)
+	}
+
+	if _, ok := values["lxc_container_network_receive_bytes_total/lo"]; !ok {
+		t.Errorf("expected the loopback interface to be reported")
+	}
+}
+
+func metricValue(metric *dto.Metric) float64 {
+	switch {
+	case metric.Gauge != nil:
+		return metric.Gauge.GetValue()
+	case metric.Counter != nil:
+		return metric.Counter.GetValue()
+	}
+	return 0
+}
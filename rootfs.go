@@ -0,0 +1,254 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+// #include <lxc/lxccontainer.h>
+// #include <lxc/version.h>
+// #include "lxc-binding.h"
+import "C"
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// CreateFromRootfs creates the container from a local root filesystem
+// instead of a template, without any network access. The root filesystem is
+// unpacked onto the backend selected by options, overlay and aufs aren't
+// supported as LXC only creates them as snapshots of another container.
+// The configuration is generated from the system's default one, with the
+// architecture and hostname set and a veth interface on lxcbr0 added if it
+// doesn't define any network. The ownership of the files is shifted when
+// lxc.idmap is set for unprivileged containers. Template, the download
+// related options and ExtraArgs are ignored.
+func (c *Container) CreateFromRootfs(options TemplateOptions, source RootfsSource) error {
+	if (source.Tarball == "") == (source.Dir == "") {
+		return fmt.Errorf("%s: exactly one of Tarball and Dir has to be set", ErrInvalidRootfsSource)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.makeSure(isNotDefined); err != nil {
+		return err
+	}
+
+	// Fail before creating anything if the source is unusable.
+	var tarball io.Reader
+	if source.Tarball != "" {
+		f, err := os.Open(source.Tarball)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ErrInvalidRootfsSource, err)
+		}
+		defer f.Close()
+		tarball = f
+	} else if info, err := os.Stat(source.Dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s: %q isn't a directory", ErrInvalidRootfsSource, source.Dir)
+	}
+
+	if err := c.createWithoutTemplate(options); err != nil {
+		return err
+	}
+
+	if err := c.populateRootfs(tarball, source.Dir); err != nil {
+		C.go_lxc_destroy(c.container)
+		return err
+	}
+
+	return nil
+}
+
+// createWithoutTemplate creates the container with an empty root filesystem
+// and a minimal configuration.
+//
+// Caller needs to hold the lock
+func (c *Container) createWithoutTemplate(options TemplateOptions) error {
+	if options.Backend == 0 {
+		options.Backend = Directory
+	}
+
+	switch options.Backend {
+	case Overlayfs, Aufs:
+		return fmt.Errorf("%s: %s", ErrUnsupportedRootfs, options.Backend)
+	}
+
+	bdevspecs := buildBdevSpecs(options.BackendSpecs)
+
+	ctemplate := C.CString("none")
+	defer C.free(unsafe.Pointer(ctemplate))
+
+	cbackend := C.CString(options.Backend.String())
+	defer C.free(unsafe.Pointer(cbackend))
+
+	if !bool(C.go_lxc_create(c.container, ctemplate, cbackend, bdevspecs, C.int(c.verbosity), nil)) {
+		return ErrCreateFailed
+	}
+
+	if err := c.writeMinimalConfig(options.Arch); err != nil {
+		C.go_lxc_destroy(c.container)
+		return err
+	}
+
+	return nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) populateRootfs(tarball io.Reader, dir string) error {
+	root, unmount, err := c.mountRootfs(false)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	var r io.Reader = tarball
+	if tarball == nil {
+		// Copying through a tar stream shares the ownership shifting and
+		// the preservation of hard links, xattrs and devices.
+		pr, pw := io.Pipe()
+		go func() {
+			tw := tar.NewWriter(pw)
+			err := newTarArchiver(tw).add(dir, "", nil)
+			if err == nil {
+				err = tw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		defer pr.Close()
+		r = pr
+	}
+
+	dr, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	return extractTar(tar.NewReader(dr), root, c.idmap(), false)
+}
+
+// Caller needs to hold the lock
+func (c *Container) writeMinimalConfig(arch string) error {
+	if arch != "" {
+		if err := c.setConfigItem("lxc.arch", arch); err != nil {
+			return err
+		}
+	}
+
+	utsname := "lxc.uts.name"
+	netPrefix := "lxc.net"
+	if !VersionAtLeast(2, 1, 0) {
+		utsname = "lxc.utsname"
+		netPrefix = "lxc.network"
+	}
+
+	if c.configItem(utsname)[0] == "" {
+		if err := c.setConfigItem(utsname, c.name()); err != nil {
+			return err
+		}
+	}
+
+	if c.configItem(netPrefix + ".0.type")[0] == "" {
+		for _, item := range [][2]string{
+			{netPrefix + ".0.type", "veth"},
+			{netPrefix + ".0.link", "lxcbr0"},
+			{netPrefix + ".0.flags", "up"},
+		} {
+			if err := c.setConfigItem(item[0], item[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.saveConfigFile(c.configFileName())
+}
+
+// mountRootfs makes the root filesystem of the stopped container accessible
+// on the host, mounting block based and layered backends on a temporary
+// directory. Layered backends can only be mounted read-only.
+//
+// Caller needs to hold the lock
+func (c *Container) mountRootfs(readOnly bool) (string, func(), error) {
+	backend, source, lower, err := parseRootfsPath(c.rootfsPath())
+	if err != nil {
+		return "", nil, err
+	}
+
+	if backend == Directory || backend == Btrfs {
+		return source, func() {}, nil
+	}
+
+	mountpoint, err := ioutil.TempDir("", "go-lxc-rootfs-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	args, err := mountRootfsArgs(backend, source, lower, mountpoint, readOnly)
+	if err != nil {
+		os.Remove(mountpoint)
+		return "", nil, err
+	}
+
+	if err := runCommand("mount", args...); err != nil {
+		os.Remove(mountpoint)
+		return "", nil, err
+	}
+
+	return mountpoint, func() {
+		_ = runCommand("umount", mountpoint)
+		os.Remove(mountpoint)
+	}, nil
+}
+
+// mountRootfsArgs returns the arguments of mount(8) mounting the root
+// filesystem on mountpoint.
+func mountRootfsArgs(backend BackendStore, source string, lower string, mountpoint string, readOnly bool) ([]string, error) {
+	var options []string
+	if readOnly {
+		options = append(options, "ro")
+	}
+
+	var args []string
+	switch backend {
+	case LVM:
+	case Loopback:
+		options = append(options, "loop")
+	case ZFS:
+		// zfsutil allows mounting datasets whose mountpoint isn't legacy.
+		args = []string{"-t", "zfs"}
+		options = append(options, "zfsutil")
+	case Overlayfs, Aufs:
+		// Mounting the upper directory as a lower layer doesn't need a
+		// work directory and leaves both layers untouched.
+		if !readOnly {
+			return nil, fmt.Errorf("%s: %s can only be mounted read-only", ErrUnsupportedRootfs, backend)
+		}
+
+		if backend == Overlayfs {
+			args = []string{"-t", "overlay"}
+			options = append(options, fmt.Sprintf("lowerdir=%s:%s", source, lower))
+			source = "overlay"
+		} else {
+			args = []string{"-t", "aufs"}
+			options = append(options, fmt.Sprintf("br=%s=ro:%s=ro", source, lower))
+			source = "none"
+		}
+	default:
+		return nil, fmt.Errorf("%s: %s", ErrUnsupportedRootfs, backend)
+	}
+
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+
+	return append(args, source, mountpoint), nil
+}
@@ -0,0 +1,254 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Sample represents the resource usage of a container between two consecutive
+// Stats snapshots. Rates are per second.
+type Sample struct {
+	Time     time.Time
+	Interval time.Duration
+
+	// CPU is the CPU usage in percent of the CPUs the container may run on
+	// (its cpuset), 100 meaning all of them were busy for the whole interval.
+	CPU float64
+	// CPUs is the number of CPUs in the container's cpuset.
+	CPUs int
+
+	// Memory is the current memory usage and MemoryDelta its change since
+	// the previous sample.
+	Memory      ByteSize
+	MemoryDelta ByteSize
+
+	// NetworkRx is the traffic received and NetworkTx the traffic sent by
+	// the container, over all its interfaces but the loopback.
+	NetworkRx ByteSize
+	NetworkTx ByteSize
+
+	BlockIORead  ByteSize
+	BlockIOWrite ByteSize
+	ReadIOPS     float64
+	WriteIOPS    float64
+}
+
+// Sampler polls the resource usage of containers at a fixed interval and keeps
+// a bounded history of the samples of every container.
+type Sampler struct {
+	options SamplerOptions
+
+	mu      sync.Mutex
+	history map[string]*sampleRing
+}
+
+// NewSampler returns a new sampler using the given options.
+func NewSampler(options SamplerOptions) *Sampler {
+	if options.Interval <= 0 {
+		options.Interval = DefaultSamplerOptions.Interval
+	}
+
+	return &Sampler{
+		options: options,
+		history: make(map[string]*sampleRing),
+	}
+}
+
+// Sample starts sampling the container and delivers a sample on the returned
+// channel every interval. The channel is closed once the context is done or
+// the container stops. The container must not be released before that.
+func (s *Sampler) Sample(ctx context.Context, c *Container) (<-chan Sample, error) {
+	previous, err := c.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	key := samplerKey(c)
+	s.mu.Lock()
+	if _, ok := s.history[key]; !ok {
+		s.history[key] = newSampleRing(s.options.History)
+	}
+	s.mu.Unlock()
+
+	ch := make(chan Sample)
+
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(s.options.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := c.Stats()
+			if err != nil {
+				return
+			}
+
+			sample := newSample(previous, current, c.cpusetSize())
+			previous = current
+
+			s.mu.Lock()
+			if history, ok := s.history[key]; ok {
+				history.add(sample)
+			}
+			s.mu.Unlock()
+
+			select {
+			case ch <- sample:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// History returns the samples kept for the container, oldest first.
+func (s *Sampler) History(c *Container) []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, ok := s.history[samplerKey(c)]
+	if !ok {
+		return nil
+	}
+	return history.samples()
+}
+
+// Forget drops the samples kept for the container.
+func (s *Sampler) Forget(c *Container) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.history, samplerKey(c))
+}
+
+func samplerKey(c *Container) string {
+	return filepath.Join(c.ConfigPath(), c.Name())
+}
+
+// cpusetSize returns the number of CPUs the container may run on.
+func (c *Container) cpusetSize() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := []string{"cpuset.effective_cpus", "cpuset.cpus"}
+	if cgroup2() {
+		keys = []string{"cpuset.cpus.effective", "cpuset.cpus"}
+	}
+
+	for _, key := range keys {
+		cpus, err := parseCPUList(c.cgroupValue(key))
+		if err == nil && len(cpus) > 0 {
+			return len(cpus)
+		}
+	}
+
+	return runtime.NumCPU()
+}
+
+// newSample computes the sample between two snapshots. Counters going
+// backwards (e.g. an interface being recreated) are treated as no activity.
+func newSample(previous *Stats, current *Stats, cpus int) Sample {
+	interval := current.Time.Sub(previous.Time)
+
+	sample := Sample{
+		Time:        current.Time,
+		Interval:    interval,
+		CPUs:        cpus,
+		Memory:      current.Memory.Usage,
+		MemoryDelta: current.Memory.Usage - previous.Memory.Usage,
+	}
+
+	if interval <= 0 {
+		return sample
+	}
+	seconds := interval.Seconds()
+
+	rate := func(current float64, previous float64) float64 {
+		if current < previous {
+			return 0
+		}
+		return (current - previous) / seconds
+	}
+
+	if cpus > 0 && current.CPU.Usage >= previous.CPU.Usage {
+		sample.CPU = float64(current.CPU.Usage-previous.CPU.Usage) / float64(interval) / float64(cpus) * 100
+	}
+
+	for name, network := range current.Network {
+		old, ok := previous.Network[name]
+		if !ok || name == "lo" {
+			continue
+		}
+
+		sample.NetworkRx += ByteSize(rate(float64(network.Rx.Bytes), float64(old.Rx.Bytes)))
+		sample.NetworkTx += ByteSize(rate(float64(network.Tx.Bytes), float64(old.Tx.Bytes)))
+	}
+
+	for _, device := range current.BlockIO {
+		for _, old := range previous.BlockIO {
+			if old.Major != device.Major || old.Minor != device.Minor {
+				continue
+			}
+
+			sample.BlockIORead += ByteSize(rate(float64(device.ReadBytes), float64(old.ReadBytes)))
+			sample.BlockIOWrite += ByteSize(rate(float64(device.WriteBytes), float64(old.WriteBytes)))
+			sample.ReadIOPS += rate(float64(device.ReadOps), float64(old.ReadOps))
+			sample.WriteIOPS += rate(float64(device.WriteOps), float64(old.WriteOps))
+			break
+		}
+	}
+
+	return sample
+}
+
+// sampleRing is a fixed size ring buffer of samples.
+type sampleRing struct {
+	buffer []Sample
+	next   int
+	full   bool
+}
+
+func newSampleRing(size int) *sampleRing {
+	if size < 0 {
+		size = 0
+	}
+	return &sampleRing{buffer: make([]Sample, size)}
+}
+
+func (r *sampleRing) add(sample Sample) {
+	if len(r.buffer) == 0 {
+		return
+	}
+
+	r.buffer[r.next] = sample
+	r.next = (r.next + 1) % len(r.buffer)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *sampleRing) samples() []Sample {
+	if !r.full {
+		return append([]Sample(nil), r.buffer[:r.next]...)
+	}
+	return append(append([]Sample(nil), r.buffer[r.next:]...), r.buffer[:r.next]...)
+}
@@ -0,0 +1,216 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// snapshotTimestampLayout is the local time format liblxc stores in the ts
+// file of a snapshot.
+const snapshotTimestampLayout = "2006:01:02 15:04:05"
+
+func parseSnapshotTimestamp(timestamp string) (time.Time, error) {
+	return time.ParseInLocation(snapshotTimestampLayout, strings.TrimSpace(timestamp), time.Local)
+}
+
+// Comment returns the comment stored along with the snapshot, empty if it has none.
+func (s Snapshot) Comment() (string, error) {
+	if s.CommentPath == "" {
+		return "", nil
+	}
+
+	content, err := ioutil.ReadFile(s.CommentPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// Size returns the disk space used by the snapshot's directory. Files shared
+// through hard links are counted once. For block based backends (e.g. LVM or
+// ZFS) the snapshot's root filesystem isn't part of the directory and only
+// its configuration is accounted for.
+func (s Snapshot) Size() (ByteSize, error) {
+	return diskUsage(filepath.Join(s.Path, s.Name))
+}
+
+// diskUsage returns the space allocated to the files below root. Files
+// removed during the walk, e.g. by a running container, are skipped.
+func diskUsage(root string) (ByteSize, error) {
+	type inode struct {
+		dev uint64
+		ino uint64
+	}
+
+	var size ByteSize
+	seen := make(map[inode]bool)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path != root {
+				return nil
+			}
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			size += ByteSize(info.Size())
+			return nil
+		}
+
+		if stat.Nlink > 1 && !info.IsDir() {
+			key := inode{dev: uint64(stat.Dev), ino: stat.Ino}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+
+		// st_blocks is always in 512 byte units.
+		size += ByteSize(stat.Blocks * 512)
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return size, nil
+}
+
+// RetentionPolicy describes which snapshots PruneSnapshots keeps. A snapshot
+// is kept if any of the Keep rules selects it. Without any Keep rule all the
+// snapshots are kept and only MaxAge applies.
+type RetentionPolicy struct {
+	// KeepLast keeps the given number of most recent snapshots.
+	KeepLast int
+
+	// KeepDaily and KeepWeekly keep the most recent snapshot of each of the
+	// given number of most recent days and (ISO) weeks having snapshots.
+	KeepDaily  int
+	KeepWeekly int
+
+	// MaxAge removes the snapshots older than it, whatever the Keep rules say.
+	MaxAge time.Duration
+}
+
+// PruneResult represents the outcome of PruneSnapshots.
+type PruneResult struct {
+	Removed []Snapshot
+
+	// Failed holds the error of each snapshot that couldn't be destroyed, keyed by name.
+	Failed map[string]error
+}
+
+// SnapshotsToPrune returns the snapshots PruneSnapshots would destroy
+// for the given policy, without destroying them.
+func (c *Container) SnapshotsToPrune(policy RetentionPolicy) ([]Snapshot, error) {
+	snapshots, err := c.Snapshots()
+	if err != nil {
+		if err == ErrNoSnapshot {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return selectSnapshotsToPrune(snapshots, policy, time.Now()), nil
+}
+
+// PruneSnapshots destroys the snapshots not kept by the policy. Snapshots that
+// fail to be destroyed are reported in the result and don't stop the pruning.
+func (c *Container) PruneSnapshots(policy RetentionPolicy) (*PruneResult, error) {
+	snapshots, err := c.SnapshotsToPrune(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PruneResult{Failed: make(map[string]error)}
+	for _, snapshot := range snapshots {
+		if err := c.DestroySnapshot(snapshot); err != nil {
+			result.Failed[snapshot.Name] = err
+			continue
+		}
+		result.Removed = append(result.Removed, snapshot)
+	}
+
+	return result, nil
+}
+
+// selectSnapshotsToPrune returns the snapshots not kept by the policy, oldest
+// first. Snapshots without a valid timestamp are always kept.
+func selectSnapshotsToPrune(snapshots []Snapshot, policy RetentionPolicy, now time.Time) []Snapshot {
+	var dated []Snapshot
+	for _, snapshot := range snapshots {
+		if !snapshot.Time.IsZero() {
+			dated = append(dated, snapshot)
+		}
+	}
+
+	// Newest first.
+	sort.SliceStable(dated, func(i, j int) bool {
+		return dated[i].Time.After(dated[j].Time)
+	})
+
+	keep := make([]bool, len(dated))
+	if policy.KeepLast <= 0 && policy.KeepDaily <= 0 && policy.KeepWeekly <= 0 {
+		for i := range keep {
+			keep[i] = true
+		}
+	}
+
+	for i := 0; i < len(dated) && i < policy.KeepLast; i++ {
+		keep[i] = true
+	}
+
+	keepPeriods := func(count int, period func(t time.Time) string) {
+		seen := make(map[string]bool)
+		for i, snapshot := range dated {
+			if len(seen) >= count {
+				return
+			}
+
+			key := period(snapshot.Time)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keep[i] = true
+		}
+	}
+
+	keepPeriods(policy.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+
+	keepPeriods(policy.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+
+	var prune []Snapshot
+	for i := len(dated) - 1; i >= 0; i-- {
+		expired := policy.MaxAge > 0 && now.Sub(dated[i].Time) > policy.MaxAge
+		if !keep[i] || expired {
+			prune = append(prune, dated[i])
+		}
+	}
+
+	return prune
+}
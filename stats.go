@@ -0,0 +1,716 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userHZ is the unit (USER_HZ) of the cpuacct.stat counters.
+const userHZ = 100
+
+// Stats represents a snapshot of the resource usage of a container.
+type Stats struct {
+	// Time is when the snapshot was taken.
+	Time time.Time
+
+	Memory  MemoryStats
+	CPU     CPUAccounting
+	BlockIO []BlockIODevice
+	Pids    PidsStats
+
+	// Network is keyed by the interface name inside the container, see
+	// NetworkInterfaceStats.
+	Network map[string]*NetworkInterfaceStats
+}
+
+// MemoryStats represents the memory usage of a container.
+// Limits are -1 when unlimited.
+type MemoryStats struct {
+	Usage     ByteSize
+	Limit     ByteSize
+	SwapUsage ByteSize
+	SwapLimit ByteSize
+
+	// Cache is the page cache and RSS the anonymous memory used by the container.
+	Cache ByteSize
+	RSS   ByteSize
+}
+
+// CPUAccounting represents the CPU usage of a container.
+type CPUAccounting struct {
+	Usage  time.Duration
+	User   time.Duration
+	System time.Duration
+
+	// PerCPU is only available on cgroup v1.
+	PerCPU []time.Duration
+
+	Throttling CPUThrottling
+}
+
+// CPUThrottling represents the CFS bandwidth throttling of a container.
+type CPUThrottling struct {
+	Periods          uint64
+	ThrottledPeriods uint64
+	ThrottledTime    time.Duration
+}
+
+// BlockIODevice represents the block I/O of a container on a single device.
+type BlockIODevice struct {
+	Major uint32
+	Minor uint32
+
+	// Name is the kernel name of the device (e.g. "sda"), empty if it can't be resolved.
+	Name string
+
+	ReadBytes  ByteSize
+	WriteBytes ByteSize
+	ReadOps    uint64
+	WriteOps   uint64
+
+	// Sync and async counters are only available on cgroup v1.
+	SyncBytes  ByteSize
+	AsyncBytes ByteSize
+	SyncOps    uint64
+	AsyncOps   uint64
+
+	// Discard counters are only available on cgroup v2.
+	DiscardBytes ByteSize
+	DiscardOps   uint64
+}
+
+// PidsStats represents the number of tasks in a container.
+type PidsStats struct {
+	Current uint64
+
+	// Limit is -1 when unlimited.
+	Limit int64
+}
+
+// StatsError is returned by Stats along with the snapshot when some of the
+// subsystems couldn't be read. It wraps ErrStatsIncomplete.
+type StatsError struct {
+	// Failed holds the error of every failed subsystem, keyed by "memory",
+	// "cpu", "blkio", "pids" or "network". Their statistics are incomplete.
+	Failed map[string]error
+}
+
+func (e *StatsError) Error() string {
+	subsystems := make([]string, 0, len(e.Failed))
+	for subsystem := range e.Failed {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+
+	for i, subsystem := range subsystems {
+		subsystems[i] = fmt.Sprintf("%s: %s", subsystem, e.Failed[subsystem])
+	}
+	return fmt.Sprintf("%s: %s", ErrStatsIncomplete, strings.Join(subsystems, "; "))
+}
+
+func (e *StatsError) Unwrap() error {
+	return ErrStatsIncomplete
+}
+
+// Stats returns a snapshot of the memory, CPU, block I/O, pids and network usage of the container.
+// A subsystem failing to be read doesn't prevent reading the others: the
+// snapshot is then returned along with a *StatsError naming the failed ones.
+func (c *Container) Stats() (*Stats, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	var err error
+	stats := &Stats{Time: time.Now()}
+	failures := make(map[string]error)
+
+	failed := func(subsystem string, err error) {
+		if err != nil {
+			failures[subsystem] = err
+		}
+	}
+
+	stats.Memory, err = c.memoryStats()
+	failed("memory", err)
+
+	stats.CPU, err = c.cpuAccounting()
+	failed("cpu", err)
+
+	stats.BlockIO, err = c.blockIODevices()
+	failed("blkio", err)
+
+	stats.Pids, err = c.pidsStats()
+	failed("pids", err)
+
+	stats.Network, err = c.networkInterfaces()
+	failed("network", err)
+
+	if len(failures) > 0 {
+		return stats, &StatsError{Failed: failures}
+	}
+
+	return stats, nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) cgroupByteSize(key string) (ByteSize, error) {
+	value := c.cgroupValue(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return -1, err
+	}
+	return ByteSize(size), nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) cgroupLimit(key string) (ByteSize, error) {
+	value := c.cgroupValue(key)
+	if value == "" {
+		return -1, nil
+	}
+
+	limit, err := parseCgroupLimit(value)
+	if err != nil {
+		return -1, err
+	}
+	return ByteSize(limit), nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) cgroupFlatKeyed(key string) (map[string]uint64, error) {
+	return parseFlatKeyed(c.cgroupItem(key))
+}
+
+// Caller needs to hold the lock
+func (c *Container) memoryStats() (MemoryStats, error) {
+	var err error
+	var stats MemoryStats
+
+	if cgroup2() {
+		if stats.Usage, err = c.cgroupByteSize("memory.current"); err != nil {
+			return stats, err
+		}
+		if stats.Limit, err = c.cgroupLimit("memory.max"); err != nil {
+			return stats, err
+		}
+		if stats.SwapUsage, err = c.cgroupByteSize("memory.swap.current"); err != nil {
+			return stats, err
+		}
+		if stats.SwapLimit, err = c.cgroupLimit("memory.swap.max"); err != nil {
+			return stats, err
+		}
+
+		stat, err := c.cgroupFlatKeyed("memory.stat")
+		if err != nil {
+			return stats, err
+		}
+		stats.Cache = ByteSize(stat["file"])
+		stats.RSS = ByteSize(stat["anon"])
+
+		return stats, nil
+	}
+
+	if stats.Usage, err = c.cgroupByteSize("memory.usage_in_bytes"); err != nil {
+		return stats, err
+	}
+	if stats.Limit, err = c.cgroupLimit("memory.limit_in_bytes"); err != nil {
+		return stats, err
+	}
+
+	// memsw accounts for memory+swap, only the swap part is reported.
+	memsw, err := c.cgroupByteSize("memory.memsw.usage_in_bytes")
+	if err != nil {
+		return stats, err
+	}
+	if memsw > stats.Usage {
+		stats.SwapUsage = memsw - stats.Usage
+	}
+	if stats.SwapLimit, err = c.cgroupLimit("memory.memsw.limit_in_bytes"); err != nil {
+		return stats, err
+	}
+
+	stat, err := c.cgroupFlatKeyed("memory.stat")
+	if err != nil {
+		return stats, err
+	}
+	stats.Cache = ByteSize(stat["total_cache"])
+	stats.RSS = ByteSize(stat["total_rss"])
+
+	return stats, nil
+}
+
+// Caller needs to hold the lock
+func (c *Container) cpuAccounting() (CPUAccounting, error) {
+	var stats CPUAccounting
+
+	if cgroup2() {
+		stat, err := c.cgroupFlatKeyed("cpu.stat")
+		if err != nil {
+			return stats, err
+		}
+
+		stats.Usage = time.Duration(stat["usage_usec"]) * time.Microsecond
+		stats.User = time.Duration(stat["user_usec"]) * time.Microsecond
+		stats.System = time.Duration(stat["system_usec"]) * time.Microsecond
+		stats.Throttling = CPUThrottling{
+			Periods:          stat["nr_periods"],
+			ThrottledPeriods: stat["nr_throttled"],
+			ThrottledTime:    time.Duration(stat["throttled_usec"]) * time.Microsecond,
+		}
+
+		return stats, nil
+	}
+
+	if usage := c.cgroupValue("cpuacct.usage"); usage != "" {
+		ns, err := strconv.ParseUint(usage, 10, 64)
+		if err != nil {
+			return stats, err
+		}
+		stats.Usage = time.Duration(ns)
+	}
+
+	for _, v := range strings.Fields(c.cgroupValue("cpuacct.usage_percpu")) {
+		ns, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return stats, err
+		}
+		stats.PerCPU = append(stats.PerCPU, time.Duration(ns))
+	}
+
+	acct, err := c.cgroupFlatKeyed("cpuacct.stat")
+	if err != nil {
+		return stats, err
+	}
+	stats.User = time.Duration(acct["user"]) * time.Second / userHZ
+	stats.System = time.Duration(acct["system"]) * time.Second / userHZ
+
+	stat, err := c.cgroupFlatKeyed("cpu.stat")
+	if err != nil {
+		return stats, err
+	}
+	stats.Throttling = CPUThrottling{
+		Periods:          stat["nr_periods"],
+		ThrottledPeriods: stat["nr_throttled"],
+		ThrottledTime:    time.Duration(stat["throttled_time"]),
+	}
+
+	return stats, nil
+}
+
+// BlockIOStats returns the block I/O of the container per device.
+func (c *Container) BlockIOStats() ([]BlockIODevice, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	return c.blockIODevices()
+}
+
+// Caller needs to hold the lock
+func (c *Container) blockIODevices() ([]BlockIODevice, error) {
+	var err error
+	var devices []BlockIODevice
+
+	if cgroup2() {
+		devices, err = parseIOStat(c.cgroupItem("io.stat"))
+	} else {
+		devices, err = parseBlkioThrottle(c.cgroupItem("blkio.throttle.io_service_bytes"), c.cgroupItem("blkio.throttle.io_serviced"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range devices {
+		devices[i].Name = blockDeviceName(devices[i].Major, devices[i].Minor)
+	}
+
+	return devices, nil
+}
+
+// blockDeviceName resolves a device number to its kernel name using /sys/dev/block.
+func blockDeviceName(major uint32, minor uint32) string {
+	target, err := os.Readlink(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// parseIOStat parses the cgroup v2 io.stat file.
+func parseIOStat(lines []string) ([]BlockIODevice, error) {
+	stat, err := parseNestedKeyed(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]BlockIODevice, 0, len(stat))
+	for device, values := range stat {
+		major, minor, err := parseDeviceNumber(device)
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, BlockIODevice{
+			Major:      major,
+			Minor:      minor,
+			ReadBytes:  ByteSize(values["rbytes"]),
+			WriteBytes: ByteSize(values["wbytes"]),
+			ReadOps:    values["rios"],
+			WriteOps:   values["wios"],
+
+			DiscardBytes: ByteSize(values["dbytes"]),
+			DiscardOps:   values["dios"],
+		})
+	}
+	sortBlockIODevices(devices)
+
+	return devices, nil
+}
+
+// parseBlkioThrottle parses the cgroup v1 blkio.throttle.io_service_bytes and
+// blkio.throttle.io_serviced files.
+func parseBlkioThrottle(serviceBytes []string, serviced []string) ([]BlockIODevice, error) {
+	devices := make(map[string]*BlockIODevice)
+
+	parse := func(lines []string, fn func(device *BlockIODevice, op string, value uint64)) error {
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			// Skip empty lines and the trailing "Total" line.
+			if len(fields) != 3 {
+				continue
+			}
+
+			value, err := strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				return err
+			}
+
+			device, ok := devices[fields[0]]
+			if !ok {
+				major, minor, err := parseDeviceNumber(fields[0])
+				if err != nil {
+					return err
+				}
+
+				device = &BlockIODevice{Major: major, Minor: minor}
+				devices[fields[0]] = device
+			}
+			fn(device, fields[1], value)
+		}
+		return nil
+	}
+
+	err := parse(serviceBytes, func(device *BlockIODevice, op string, value uint64) {
+		switch op {
+		case "Read":
+			device.ReadBytes = ByteSize(value)
+		case "Write":
+			device.WriteBytes = ByteSize(value)
+		case "Sync":
+			device.SyncBytes = ByteSize(value)
+		case "Async":
+			device.AsyncBytes = ByteSize(value)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = parse(serviced, func(device *BlockIODevice, op string, value uint64) {
+		switch op {
+		case "Read":
+			device.ReadOps = value
+		case "Write":
+			device.WriteOps = value
+		case "Sync":
+			device.SyncOps = value
+		case "Async":
+			device.AsyncOps = value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]BlockIODevice, 0, len(devices))
+	for _, device := range devices {
+		result = append(result, *device)
+	}
+	sortBlockIODevices(result)
+
+	return result, nil
+}
+
+func sortBlockIODevices(devices []BlockIODevice) {
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Major != devices[j].Major {
+			return devices[i].Major < devices[j].Major
+		}
+		return devices[i].Minor < devices[j].Minor
+	})
+}
+
+// Caller needs to hold the lock
+func (c *Container) pidsStats() (PidsStats, error) {
+	stats := PidsStats{Limit: -1}
+
+	if current := c.cgroupValue("pids.current"); current != "" {
+		value, err := strconv.ParseUint(current, 10, 64)
+		if err != nil {
+			return stats, err
+		}
+		stats.Current = value
+	}
+
+	if limit := c.cgroupValue("pids.max"); limit != "" {
+		value, err := parseCgroupLimit(limit)
+		if err != nil {
+			return stats, err
+		}
+		stats.Limit = value
+	}
+
+	return stats, nil
+}
+
+// NetworkCounters represents the traffic counters of one direction of a network interface.
+type NetworkCounters struct {
+	Bytes   ByteSize
+	Packets uint64
+	Errors  uint64
+	Dropped uint64
+}
+
+// NetworkInterfaceStats represents the counters of a network interface as seen
+// from inside the container, so Rx is the traffic received by the container.
+type NetworkInterfaceStats struct {
+	// Name is the name of the interface inside the container and HostName the
+	// name of its host side peer, empty if it has none (e.g. phys or loopback).
+	Name     string
+	HostName string
+
+	// Type is the configured network type, empty for interfaces not created by LXC.
+	Type string
+
+	Rx NetworkCounters
+	Tx NetworkCounters
+
+	// Multicast is the number of multicast packets received.
+	Multicast uint64
+}
+
+// NetworkInterfaceStats returns the counters of all the network interfaces of
+// the container. They are read from within the container's network namespace,
+// so interfaces without a host side peer are included as well. The returned
+// map is keyed by both the container side and the host side names, which
+// refer to the same entry.
+func (c *Container) NetworkInterfaceStats() (map[string]*NetworkInterfaceStats, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.makeSure(isRunning); err != nil {
+		return nil, err
+	}
+
+	interfaces, err := c.networkInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	statistics := make(map[string]*NetworkInterfaceStats, len(interfaces))
+	for name, stats := range interfaces {
+		statistics[name] = stats
+		if stats.HostName != "" {
+			statistics[stats.HostName] = stats
+		}
+	}
+
+	return statistics, nil
+}
+
+// networkInterfaces returns the counters of the network interfaces of the
+// container keyed by their name inside the container.
+//
+// Caller needs to hold the lock
+func (c *Container) networkInterfaces() (map[string]*NetworkInterfaceStats, error) {
+	pid := c.initPid()
+	if pid <= 0 {
+		return nil, fmt.Errorf("%s: %q", ErrNotRunning, c.name())
+	}
+
+	// /proc/<pid>/net/dev shows the network namespace of the given process.
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces, err := parseNetDev(strings.Split(string(content), "\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	netPrefix := "lxc.net"
+	if !VersionAtLeast(2, 1, 0) {
+		netPrefix = "lxc.network"
+	}
+
+	for i := 0; i < len(c.configItem(netPrefix)); i++ {
+		interfaceType := c.runningConfigItem(fmt.Sprintf("%s.%d.type", netPrefix, i))
+		if interfaceType == nil {
+			continue
+		}
+
+		var name, hostName string
+		if value := c.runningConfigItem(fmt.Sprintf("%s.%d.name", netPrefix, i)); len(value) > 0 {
+			name = value[0]
+		}
+
+		switch interfaceType[0] {
+		case "veth":
+			if value := c.runningConfigItem(fmt.Sprintf("%s.%d.veth.pair", netPrefix, i)); len(value) > 0 {
+				hostName = value[0]
+			}
+		case "phys":
+			// The device is moved into the container, keeping its name unless one is configured.
+			if name == "" {
+				if value := c.runningConfigItem(fmt.Sprintf("%s.%d.link", netPrefix, i)); len(value) > 0 {
+					name = value[0]
+				}
+			}
+		}
+
+		// Without a configured name the kernel picked one, found through
+		// the peer of the host side veth or else LXC's eth<N> default.
+		if name == "" && hostName != "" {
+			name = peerInterfaceName(pid, hostName)
+		}
+		if name == "" {
+			name = fmt.Sprintf("eth%d", i)
+		}
+
+		stats, ok := interfaces[name]
+		if !ok {
+			continue
+		}
+
+		stats.HostName = hostName
+		stats.Type = interfaceType[0]
+	}
+
+	return interfaces, nil
+}
+
+// peerInterfaceName returns the name of the peer of the host side veth
+// hostName in the network namespace of the given process, empty if it
+// couldn't be found.
+func peerInterfaceName(pid int, hostName string) string {
+	content, err := ioutil.ReadFile(filepath.Join("/sys/class/net", hostName, "iflink"))
+	if err != nil {
+		return ""
+	}
+
+	index, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return ""
+	}
+
+	// /proc/<pid>/net/igmp lists the interfaces of the namespace by index.
+	content, err = ioutil.ReadFile(fmt.Sprintf("/proc/%d/net/igmp", pid))
+	if err != nil {
+		return ""
+	}
+
+	return parseIGMPInterfaces(strings.Split(string(content), "\n"))[index]
+}
+
+// parseIGMPInterfaces parses the content of /proc/net/igmp into the names of
+// the interfaces keyed by their index.
+func parseIGMPInterfaces(lines []string) map[int]string {
+	interfaces := make(map[int]string)
+
+	for _, line := range lines {
+		// Group lines are indented, interface lines start with the index.
+		fields := strings.Fields(line)
+		if len(fields) < 2 || line[0] == '\t' || line[0] == ' ' {
+			continue
+		}
+
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		interfaces[index] = fields[1]
+	}
+
+	return interfaces
+}
+
+// parseNetDev parses the content of /proc/net/dev.
+func parseNetDev(lines []string) (map[string]*NetworkInterfaceStats, error) {
+	interfaces := make(map[string]*NetworkInterfaceStats)
+
+	for _, line := range lines {
+		// Skip the two header lines.
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.Contains(parts[0], "|") {
+			continue
+		}
+
+		fields := strings.Fields(parts[1])
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("malformed network device line %q", line)
+		}
+
+		values := make([]uint64, len(fields))
+		for i, field := range fields {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+
+		name := strings.TrimSpace(parts[0])
+		interfaces[name] = &NetworkInterfaceStats{
+			Name: name,
+			Rx: NetworkCounters{
+				Bytes:   ByteSize(values[0]),
+				Packets: values[1],
+				Errors:  values[2],
+				Dropped: values[3],
+			},
+			Tx: NetworkCounters{
+				Bytes:   ByteSize(values[8]),
+				Packets: values[9],
+				Errors:  values[10],
+				Dropped: values[11],
+			},
+			Multicast: values[7],
+		}
+	}
+
+	return interfaces, nil
+}
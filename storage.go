@@ -0,0 +1,366 @@
+// Copyright © 2013, 2014, The Go-LXC Authors. All rights reserved.
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+
+//go:build linux && cgo
+// +build linux,cgo
+
+package lxc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// StorageInfo represents the storage of a container's root filesystem.
+type StorageInfo struct {
+	Backend BackendStore
+
+	// Source is where the root filesystem lives: a directory for dir and
+	// btrfs, a dataset for zfs, a block device for lvm, the image file for
+	// loop and the upper directory for overlayfs and aufs.
+	Source string
+
+	// Lower is the read-only layer of overlayfs and aufs.
+	Lower string
+
+	// FSType is the type of the root filesystem, empty if unknown.
+	FSType string
+
+	// Size is the size of the block device or image, -1 for backends
+	// without a fixed size. For lvm and loop it is the size of the device
+	// only, the filesystem on it may be smaller.
+	Size ByteSize
+
+	// Used is the space used by the root filesystem, -1 if it can't be
+	// determined (e.g. block based backends of stopped containers). For
+	// overlayfs and aufs only the upper directory is accounted for.
+	Used ByteSize
+}
+
+// parseRootfsPath splits a lxc.rootfs.path value into its backend, source
+// and, for overlay based backends, lower layer.
+func parseRootfsPath(rootfs string) (BackendStore, string, string, error) {
+	if strings.HasPrefix(rootfs, "/") {
+		return Directory, rootfs, "", nil
+	}
+
+	parts := strings.SplitN(rootfs, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", "", fmt.Errorf("%s: %q", ErrUnknownBackendStore, rootfs)
+	}
+
+	switch parts[0] {
+	case "dir":
+		return Directory, parts[1], "", nil
+	case "btrfs":
+		return Btrfs, parts[1], "", nil
+	case "zfs":
+		return ZFS, parts[1], "", nil
+	case "lvm":
+		return LVM, parts[1], "", nil
+	case "loop":
+		return Loopback, parts[1], "", nil
+	case "overlay", "overlayfs", "aufs":
+		backend := Overlayfs
+		if parts[0] == "aufs" {
+			backend = Aufs
+		}
+
+		// overlay:<lower>:<upper>
+		layers := strings.SplitN(parts[1], ":", 2)
+		if len(layers) != 2 {
+			return 0, "", "", fmt.Errorf("%s: %q", ErrUnknownBackendStore, rootfs)
+		}
+		return backend, layers[1], layers[0], nil
+	}
+
+	return 0, "", "", fmt.Errorf("%s: %q", ErrUnknownBackendStore, rootfs)
+}
+
+// Storage returns information about the storage of the container's root filesystem.
+func (c *Container) Storage() (*StorageInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.container == nil {
+		return nil, ErrNotDefined
+	}
+
+	if err := c.makeSure(isDefined); err != nil {
+		return nil, err
+	}
+
+	backend, source, lower, err := parseRootfsPath(c.rootfsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	info := &StorageInfo{
+		Backend: backend,
+		Source:  source,
+		Lower:   lower,
+		Size:    -1,
+		Used:    -1,
+	}
+
+	switch backend {
+	case Directory, Btrfs, Overlayfs, Aufs:
+		if info.Used, err = diskUsage(source); err != nil {
+			return nil, err
+		}
+
+		switch backend {
+		case Directory:
+			info.FSType = statfsType(source)
+		case Btrfs:
+			info.FSType = "btrfs"
+		case Overlayfs:
+			info.FSType = "overlay"
+		case Aufs:
+			info.FSType = "aufs"
+		}
+	case ZFS:
+		info.FSType = "zfs"
+	case LVM, Loopback:
+		if info.Size, err = deviceSize(source); err != nil {
+			return nil, err
+		}
+
+		if info.FSType, err = probeFilesystem(source); err != nil {
+			return nil, err
+		}
+	}
+
+	// The root filesystem of datasets and block devices is only mounted
+	// while the container runs.
+	if info.Used < 0 && c.running() {
+		var fs unix.Statfs_t
+		if err := unix.Statfs(fmt.Sprintf("/proc/%d/root", c.initPid()), &fs); err == nil {
+			info.Used = ByteSize((fs.Blocks - fs.Bfree) * uint64(fs.Bsize))
+		}
+	}
+
+	return info, nil
+}
+
+// deviceSize returns the size of a block device or a file.
+func deviceSize(path string) (ByteSize, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1, err
+	}
+	return ByteSize(size), nil
+}
+
+// statfsType returns the type of the filesystem holding path, empty if unknown.
+func statfsType(path string) string {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return ""
+	}
+
+	switch uint32(fs.Type) {
+	case unix.EXT4_SUPER_MAGIC:
+		return "ext4"
+	case unix.XFS_SUPER_MAGIC:
+		return "xfs"
+	case unix.BTRFS_SUPER_MAGIC:
+		return "btrfs"
+	case unix.TMPFS_MAGIC:
+		return "tmpfs"
+	case unix.OVERLAYFS_SUPER_MAGIC:
+		return "overlay"
+	case zfsSuperMagic:
+		return "zfs"
+	}
+	return ""
+}
+
+// zfsSuperMagic is the f_type reported by ZFS, which isn't part of the kernel headers.
+const zfsSuperMagic = 0x2fc12fc1
+
+// Offsets of the superblock magic numbers identifying the filesystems
+// LXC creates on block devices.
+const (
+	ext4MagicOffset  = 1024 + 56
+	xfsMagicOffset   = 0
+	btrfsMagicOffset = 0x10000 + 64
+)
+
+// probeFilesystem returns the type of the filesystem on a block device or image.
+func probeFilesystem(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, btrfsMagicOffset+8)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	return probeSuperblock(buf[:n]), nil
+}
+
+// probeSuperblock identifies a filesystem from the start of its device.
+func probeSuperblock(buf []byte) string {
+	if len(buf) >= btrfsMagicOffset+8 && bytes.Equal(buf[btrfsMagicOffset:btrfsMagicOffset+8], []byte("_BHRfS_M")) {
+		return "btrfs"
+	}
+
+	if len(buf) >= xfsMagicOffset+4 && bytes.Equal(buf[xfsMagicOffset:xfsMagicOffset+4], []byte("XFSB")) {
+		return "xfs"
+	}
+
+	// ext2 and ext3 share the magic number of ext4.
+	if len(buf) >= ext4MagicOffset+2 && binary.LittleEndian.Uint16(buf[ext4MagicOffset:]) == unix.EXT4_SUPER_MAGIC {
+		return "ext4"
+	}
+
+	return ""
+}
+
+// ResizeRootfs grows the root filesystem of a stopped container to newSize.
+// Only the lvm and loop backends with ext4, xfs or btrfs filesystems can be
+// resized, ErrNotSupported is returned otherwise. Shrinking isn't supported.
+func (c *Container) ResizeRootfs(newSize ByteSize) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.container == nil {
+		return ErrNotDefined
+	}
+
+	if err := c.makeSure(isDefined | isNotRunning); err != nil {
+		return err
+	}
+
+	backend, source, _, err := parseRootfsPath(c.rootfsPath())
+	if err != nil {
+		return err
+	}
+
+	if backend != LVM && backend != Loopback {
+		return ErrNotSupported
+	}
+
+	fstype, err := probeFilesystem(source)
+	if err != nil {
+		return err
+	}
+
+	mountpoint, err := ioutil.TempDir("", "go-lxc-resize-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(mountpoint)
+
+	commands, err := growFilesystemCommands(fstype, source, mountpoint, backend == Loopback)
+	if err != nil {
+		return err
+	}
+
+	size, err := deviceSize(source)
+	if err != nil {
+		return err
+	}
+
+	if newSize < size {
+		return fmt.Errorf("%s: can't shrink %s to %s", ErrResizeRootfsFailed, size, newSize)
+	}
+
+	if newSize > size {
+		if backend == LVM {
+			// LVM rounds the size up to its extent size.
+			err = runCommand("lvextend", "--size", fmt.Sprintf("%.0fb", float64(newSize)), source)
+		} else {
+			err = os.Truncate(source, int64(newSize))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", ErrResizeRootfsFailed, err)
+		}
+	}
+
+	for _, command := range commands {
+		if err := runCommand(command[0], command[1:]...); !commandSucceeded(command[0], err) {
+			// Don't leave the filesystem mounted behind.
+			if command[0] != "umount" {
+				_ = runCommand("umount", mountpoint)
+			}
+			return fmt.Errorf("%s: %s", ErrResizeRootfsFailed, err)
+		}
+	}
+
+	return nil
+}
+
+// growFilesystemCommands returns the commands growing the filesystem on
+// source to the size of its device. ext4 is grown offline while xfs and
+// btrfs need to be mounted on mountpoint.
+func growFilesystemCommands(fstype string, source string, mountpoint string, loop bool) ([][]string, error) {
+	mount := []string{"mount", source, mountpoint}
+	if loop {
+		mount = []string{"mount", "-o", "loop", source, mountpoint}
+	}
+
+	switch fstype {
+	case "ext4":
+		// resize2fs insists on a freshly checked filesystem.
+		return [][]string{
+			{"e2fsck", "-f", "-p", source},
+			{"resize2fs", source},
+		}, nil
+	case "xfs":
+		return [][]string{
+			mount,
+			{"xfs_growfs", mountpoint},
+			{"umount", mountpoint},
+		}, nil
+	case "btrfs":
+		return [][]string{
+			mount,
+			{"btrfs", "filesystem", "resize", "max", mountpoint},
+			{"umount", mountpoint},
+		}, nil
+	}
+
+	return nil, ErrNotSupported
+}
+
+// commandSucceeded returns true if the error of the command is a success,
+// e2fsck exits with 1 once it corrected errors in the filesystem.
+func commandSucceeded(name string, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var exitErr *exec.ExitError
+	return name == "e2fsck" && errors.As(err, &exitErr) && exitErr.ExitCode() == 1
+}
+
+// runCommand runs a host tool, returning its output in the error on failure.
+func runCommand(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -94,6 +94,31 @@ func (t *BackendStore) Set(value string) error {
 	return ErrUnknownBackendStore
 }
 
+// Compression type specifies the compression of container archives.
+type Compression int
+
+const (
+	// NoCompression leaves the archive uncompressed
+	NoCompression Compression = iota
+	// GzipCompression compresses the archive with gzip
+	GzipCompression
+	// ZstdCompression compresses the archive with zstd
+	ZstdCompression
+)
+
+// Compression as string
+func (t Compression) String() string {
+	switch t {
+	case NoCompression:
+		return "none"
+	case GzipCompression:
+		return "gzip"
+	case ZstdCompression:
+		return "zstd"
+	}
+	return ""
+}
+
 // State type specifies possible container states.
 type State int
 